@@ -0,0 +1,25 @@
+package main
+
+import (
+	"crypto/subtle"
+	"github.com/ppaanngggg/finviz-proxy/pkg"
+	"net/http"
+	"strings"
+)
+
+// adminOnly requires the admin token as bearer token, admin apis are not found if no token is configured.
+func adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.AdminToken == "" {
+			notFound(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(c.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			problem(w, r, http.StatusUnauthorized, pkg.CodeUnauthorized, "invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/ppaanngggg/finviz-proxy/pkg"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var apiKeyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "finviz_proxy_api_key_requests_total",
+	Help: "Requests by api key name and result, one of allowed, rate_limited and quota_exhausted.",
+}, []string{"key", "result"})
+
+// apiKeyOf reads the api key from the X-API-Key header, the bearer token, or the auth query parameter.
+func apiKeyOf(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	return r.URL.Query().Get("auth")
+}
+
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+func setRateLimitHeaders(w http.ResponseWriter, decision pkg.KeyDecision) {
+	if decision.RateLimit > 0 {
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(decision.RateLimit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(decision.RateRemaining))
+		w.Header().Set("X-RateLimit-Reset", seconds(decision.RateReset))
+	}
+	if decision.QuotaLimit > 0 {
+		w.Header().Set("X-Quota-Limit", strconv.FormatInt(decision.QuotaLimit, 10))
+		w.Header().Set("X-Quota-Remaining", strconv.FormatInt(decision.QuotaRemaining, 10))
+		w.Header().Set("X-Quota-Reset", strconv.FormatInt(decision.QuotaReset.Unix(), 10))
+	}
+}
+
+// apiKeyAuth requires a valid api key within its limits, if api keys are configured.
+func apiKeyAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if keyStore == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		decision, err := keyStore.Allow(apiKeyOf(r))
+		switch err {
+		case nil:
+			apiKeyRequests.WithLabelValues(decision.Name, "allowed").Inc()
+			setRateLimitHeaders(w, decision)
+			next.ServeHTTP(w, withClient(r, "key:"+decision.Name))
+		case pkg.ErrInvalidAPIKey:
+			slog.WarnContext(r.Context(), "invalid api key", "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			problem(w, r, http.StatusUnauthorized, pkg.CodeUnauthorized, err.Error())
+		default:
+			code := pkg.CodeRateLimited
+			if err == pkg.ErrQuotaExhausted {
+				code = pkg.CodeQuotaExhausted
+			}
+			slog.WarnContext(r.Context(), "api key limited", "key", decision.Name, "err", err)
+			apiKeyRequests.WithLabelValues(decision.Name, code).Inc()
+			setRateLimitHeaders(w, decision)
+			w.Header().Set("Retry-After", seconds(decision.RetryAfter))
+			problem(w, r, http.StatusTooManyRequests, code, err.Error())
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAgeUntil is the seconds left until a dataset refreshed at lastModified is refreshed again.
+func maxAgeUntil(lastModified time.Time, ttl time.Duration) time.Duration {
+	maxAge := ttl - time.Since(lastModified)
+	if maxAge < 0 {
+		return 0
+	}
+	return maxAge
+}
+
+// writeCacheable renders v as json with an ETag of its content, Last-Modified and Cache-Control max-age,
+// and answers conditional GET requests with 304 if the client already has it.
+func writeCacheable(w http.ResponseWriter, r *http.Request, v any, lastModified time.Time, maxAge time.Duration) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "marshal response", "err", err)
+		internalProblem(w, r, err)
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	header := w.Header()
+	header.Set("ETag", etag)
+	if !lastModified.IsZero() {
+		header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	header.Set("Cache-Control", "max-age="+strconv.Itoa(int(maxAge.Seconds())))
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	// If-None-Match takes precedence over If-Modified-Since
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err == nil && !lastModified.Truncate(time.Second).After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// warnStale marks datasets loaded from snapshots and not refreshed from finviz yet.
+func warnStale(w http.ResponseWriter, stale bool) {
+	if stale {
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ppaanngggg/finviz-proxy/pkg"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"time"
+)
+
+type newsAndBlogs struct {
+	News  []pkg.Record `json:"news"`
+	Blogs []pkg.Record `json:"blogs"`
+}
+
+type cachedDataset[T any] struct {
+	Data      T         `json:"data"`
+	FetchedAt time.Time `json:"fetchedAt"`
+}
+
+func sessionScope() string {
+	if c.EliteLogin {
+		return "elite"
+	}
+	return "free"
+}
+
+// fetchDataset returns the dataset of name from the cache store if any replica fetched it within maxAge,
+// otherwise it is fetched from finviz and shared through the cache store. The fetched time is returned too.
+// Triggered refreshes always fetch from finviz, see pkg.IsTriggered.
+func fetchDataset[T any](
+	ctx context.Context, name string, maxAge time.Duration, fetch func(ctx context.Context) (T, error),
+) (data T, fetchedAt time.Time, err error) {
+	ctx, span := tracer.Start(ctx, "fetchDataset", trace.WithAttributes(attribute.String("dataset.name", name)))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	key := "dataset:" + name + ":" + sessionScope()
+	if pkg.IsTriggered(ctx) {
+		slog.InfoContext(ctx, "skip cached dataset of triggered refresh", "name", name)
+	} else if value, found, err := cacheStore.Get(ctx, key); err != nil {
+		slog.ErrorContext(ctx, "failed to get dataset from cache", "name", name, "err", err)
+	} else if found {
+		cached := &cachedDataset[T]{}
+		if err = json.Unmarshal(value, cached); err != nil {
+			slog.ErrorContext(ctx, "failed to decode cached dataset", "name", name, "err", err)
+		} else if time.Since(cached.FetchedAt) < maxAge {
+			slog.InfoContext(ctx, "use cached dataset", "name", name, "fetchedAt", cached.FetchedAt)
+			span.SetAttributes(attribute.Bool("cache.hit", true))
+			return cached.Data, cached.FetchedAt, nil
+		}
+	}
+	span.SetAttributes(attribute.Bool("cache.hit", false))
+	data, err = fetch(ctx)
+	if err != nil {
+		return data, time.Time{}, err
+	}
+	fetchedAt = time.Now()
+	// failing to share the dataset is logged only
+	if value, err := json.Marshal(&cachedDataset[T]{Data: data, FetchedAt: fetchedAt}); err != nil {
+		slog.ErrorContext(ctx, "failed to encode dataset", "name", name, "err", err)
+	} else if err = cacheStore.Set(ctx, key, value, c.FallbackTTL); err != nil {
+		slog.ErrorContext(ctx, "failed to set dataset to cache", "name", name, "err", err)
+	}
+	return data, fetchedAt, nil
+}
+
+// fetches of datasets from finviz, replaced in tests
+var (
+	upstreamParams       = pkg.FetchParams
+	upstreamFutures      = pkg.FetchAllFutures
+	upstreamNewsAndBlogs = pkg.FetchAndParseNewsAndBlogs
+)
+
+func fetchParams(ctx context.Context) (*pkg.Params, time.Time, error) {
+	return fetchDataset(ctx, jobParams, c.ParamsIntervals.At(time.Now()), func(ctx context.Context) (*pkg.Params, error) {
+		return upstreamParams(ctx, c.EliteLogin)
+	})
+}
+
+func fetchFutures(ctx context.Context) (map[string]pkg.FutureQuota, time.Time, error) {
+	return fetchDataset(ctx, jobFutures, c.FuturesIntervals.At(time.Now()), func(ctx context.Context) (map[string]pkg.FutureQuota, error) {
+		return upstreamFutures(ctx, c.EliteLogin)
+	})
+}
+
+func fetchNewsAndBlogs(ctx context.Context) (*newsAndBlogs, time.Time, error) {
+	return fetchDataset(ctx, jobNews, c.NewsIntervals.At(time.Now()), func(ctx context.Context) (*newsAndBlogs, error) {
+		news, blogs, err := upstreamNewsAndBlogs(ctx, c.EliteLogin)
+		if err != nil {
+			return nil, err
+		}
+		return &newsAndBlogs{News: news, Blogs: blogs}, nil
+	})
+}
+
+func saveSnapshot(name string, data any, fetchedAt time.Time) {
+	if c.DataDir == "" {
+		return
+	}
+	if err := pkg.SaveSnapshot(c.DataDir, name, data, fetchedAt); err != nil {
+		slog.Error("failed to save snapshot", "name", name, "err", err)
+	}
+}
+
+// loadSnapshots loads the datasets persisted by the last run, they are marked stale until refreshed.
+func loadSnapshots() {
+	params := &pkg.Params{}
+	if fetchedAt, err := pkg.LoadSnapshot(c.DataDir, "params", params); err != nil {
+		slog.Warn("failed to load params snapshot", "err", err)
+	} else {
+		params.Index()
+		paramsStore.Store(params, fetchedAt, true)
+		slog.Info("loaded params snapshot", "fetchedAt", fetchedAt)
+	}
+	futures := map[string]pkg.FutureQuota{}
+	if fetchedAt, err := pkg.LoadSnapshot(c.DataDir, "futures", &futures); err != nil {
+		slog.Warn("failed to load futures snapshot", "err", err)
+	} else {
+		futuresStore.Store(futures, fetchedAt, true)
+		slog.Info("loaded futures snapshot", "fetchedAt", fetchedAt)
+	}
+	newsAndBlogs := &newsAndBlogs{}
+	if fetchedAt, err := pkg.LoadSnapshot(c.DataDir, "news", newsAndBlogs); err != nil {
+		slog.Warn("failed to load news snapshot", "err", err)
+	} else {
+		newsStore.Store(newsAndBlogs, fetchedAt, true)
+		slog.Info("loaded news snapshot", "fetchedAt", fetchedAt)
+	}
+}
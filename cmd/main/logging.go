@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/ppaanngggg/finviz-proxy/pkg"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+)
+
+const requestIDHeader = "X-Request-Id"
+
+// validRequestID limits incoming request IDs, so clients can't inject arbitrary content into logs.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// setupLogging logs as c.LogFormat at c.LogLevel, credentials from the config are redacted.
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return err
+	}
+	handler := pkg.NewLogHandler(
+		os.Stderr, c.LogFormat != "text", level,
+		c.Email, c.Password, c.AdminToken, c.RedisPassword, c.RapidAPIProxySecret,
+	)
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// requestID reuses the X-Request-Id of the request if valid, or generates one, and returns it in the response.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(pkg.WithRequestID(r.Context(), id)))
+	})
+}
+
+// logRequests logs a line for each request once served, with its request ID.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		slog.InfoContext(
+			r.Context(), "request served",
+			"method", r.Method, "path", r.URL.Path, "status", status, "bytes", ww.BytesWritten(),
+			"duration", time.Since(start), "remote", r.RemoteAddr,
+		)
+	})
+}
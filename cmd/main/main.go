@@ -3,17 +3,22 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/go-chi/chi/v5"
-	"github.com/patrickmn/go-cache"
 	"github.com/ppaanngggg/finviz-proxy/pkg"
 	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 type config struct {
@@ -24,15 +29,72 @@ type config struct {
 	EliteLogin bool          `default:"false"`
 	Email      string        `default:""`
 	Password   string        `default:""`
+	// serve stale tables while refreshing them in background, and refresh hot tables before going stale
+	CacheStaleTTL time.Duration `default:"60s"`
+	CacheHotHits  int64         `default:"0"`
+	// bound the table cache by entries and approximate bytes
+	CacheMaxEntries int    `default:"1000"`
+	CacheMaxBytes   int64  `default:"67108864"`
+	CacheEviction   string `default:"lru"`
+	// cache backend shared by tables and datasets, one of memory, bolt and redis
+	CacheBackend  string `default:"memory"`
+	CachePath     string `default:"cache.db"`
+	RedisAddr     string `default:"localhost:6379"`
+	RedisPassword string `default:""`
+	RedisDB       int    `default:"0"`
+	RedisPrefix   string `default:"finviz-proxy:"`
+	// circuit breaker around finviz
+	BreakerThreshold int           `default:"5"`
+	BreakerCooldown  time.Duration `default:"30s"`
+	FallbackTTL      time.Duration `default:"24h"`
+	// bearer token of admin apis, admin apis are disabled if empty
+	AdminToken string `default:""`
+	// persist datasets to this dir after each refresh and load them at startup, disabled if empty
+	DataDir string `default:""`
+	// refresh intervals by market phase: premarket, regular, postmarket, overnight, weekend and default
+	ParamsIntervals  pkg.Intervals `default:"default:1h"`
+	FuturesIntervals pkg.Intervals `default:"default:1m,overnight:5m,weekend:30m"`
+	NewsIntervals    pkg.Intervals `default:"default:1m,overnight:10m,weekend:30m"`
+	LoginInterval    time.Duration `default:"24h"`
+	RefreshJitter    time.Duration `default:"5s"`
+	RefreshBackoff   time.Duration `default:"1s"`
+	// deadline to drain in-flight requests and background jobs on SIGINT or SIGTERM
+	ShutdownTimeout time.Duration `default:"30s"`
+	// export traces by OTLP over http to this endpoint, like http://localhost:4318, disabled if empty
+	OtlpEndpoint     string  `default:""`
+	TraceSampleRatio float64 `default:"1"`
+	// one of debug, info, warn and error, logged as json or text
+	LogLevel  string `default:"info"`
+	LogFormat string `default:"json"`
+	// api keys file as {"keys": [...]}, reloaded when it changes, api keys are not required if empty
+	APIKeysFile   string        `default:""`
+	APIKeysReload time.Duration `default:"10s"`
+	// reject data api requests without this X-RapidAPI-Proxy-Secret, and record usage per subscriber, disabled if empty
+	RapidAPIProxySecret string        `default:""`
+	UsagePath           string        `default:"usage.db"`
+	UsageFlush          time.Duration `default:"10s"`
+	// per client rate limits of cheap routes served from memory, and expensive routes which may fetch finviz,
+	// clients are identified by api key, rapidapi user or ip, disabled if rate is 0
+	CheapRate         float64 `default:"20"`
+	CheapBurst        int     `default:"40"`
+	ExpensiveRate     float64 `default:"1"`
+	ExpensiveBurst    int     `default:"5"`
+	TrustForwardedFor bool    `default:"false"`
 }
 
 var (
-	c             config
-	globalParams  *pkg.Params
-	globalFutures map[string]pkg.FutureQuota
-	globalNews    []pkg.Record
-	globalBlogs   []pkg.Record
-	tableCache    *cache.Cache
+	c config
+	// datasets refreshed in background, handlers read their current snapshots
+	paramsStore   pkg.SnapshotStore[*pkg.Params]
+	futuresStore  pkg.SnapshotStore[map[string]pkg.FutureQuota]
+	newsStore     pkg.SnapshotStore[*newsAndBlogs]
+	eliteLoggedIn atomic.Bool
+	cacheStore    pkg.CacheStore
+	tableCache    *pkg.TableCache
+	tableGroup    = pkg.NewCoalescer[*pkg.CachedTable]()
+	scheduler     = pkg.NewScheduler()
+	keyStore      *pkg.KeyStore
+	usageStore    *pkg.UsageStore
 )
 
 func init() {
@@ -40,198 +102,105 @@ func init() {
 	if err := envconfig.Process("", &c); err != nil {
 		panic(err)
 	}
+	if err := setupLogging(); err != nil {
+		panic(err)
+	}
 	// init cache
-	tableCache = cache.New(c.CacheTTL, c.CacheTTL)
+	store, err := newCacheStore()
+	if err != nil {
+		panic(err)
+	}
+	cacheStore = store
+	tableCache = pkg.NewTableCache(pkg.CachePolicy{
+		FreshTTL:    c.CacheTTL,
+		StaleTTL:    c.CacheStaleTTL,
+		RetainTTL:   c.FallbackTTL,
+		HotRequests: c.CacheHotHits,
+	}, cacheStore)
+	// init circuit breakers
+	pkg.ConfigureBreakers(c.BreakerThreshold, c.BreakerCooldown)
 	// elite login
-	if c.EliteLogin {
-		if c.Email == "" || c.Password == "" {
-			panic("email or password is empty")
-		}
-		ok, err := pkg.EliteLogin(context.Background(), c.Email, c.Password)
-		if err != nil {
-			panic(err)
-		}
-		if !ok {
-			panic("login failed")
-		}
-		slog.Info("login success")
-		go func() {
-			for {
-				time.Sleep(24 * time.Hour)
-				func() {
-					slog.Info("login...")
-					ok, err = pkg.EliteLogin(context.Background(), c.Email, c.Password)
-					if err != nil {
-						slog.Error("login err", "err", err)
-						return
-					}
-					if !ok {
-						slog.Error("login failed")
-						return
-					}
-					slog.Info("login success")
-				}()
-			}
-		}()
+	if c.EliteLogin && (c.Email == "" || c.Password == "") {
+		panic("email or password is empty")
 	}
-	// fetch params
-	func() {
-		params, err := pkg.FetchParams(context.Background(), c.EliteLogin)
-		if err != nil {
-			panic(err)
-		}
-		globalParams = params
-	}()
-	go func() {
-		for {
-			time.Sleep(time.Hour)
-			func() {
-				slog.Info("fetching params...")
-				ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
-				defer cancel()
-				params, err := pkg.FetchParams(ctx, c.EliteLogin)
-				if err != nil {
-					slog.Error("fetch params err", "err", err)
-					return
-				}
-				globalParams = params
-				slog.Info("fetch params success")
-			}()
-		}
-	}()
-	// fetch futures
-	func() {
-		futures, err := pkg.FetchAllFutures(context.Background(), c.EliteLogin)
-		if err != nil {
+	// load persisted datasets as stale baseline
+	if c.DataDir != "" {
+		loadSnapshots()
+	}
+	// login and load datasets in background, so the server starts even if finviz is unreachable
+	if err = registerJobs(); err != nil {
+		panic(err)
+	}
+	if c.APIKeysFile != "" {
+		if keyStore, err = pkg.NewKeyStore(c.APIKeysFile); err != nil {
 			panic(err)
 		}
-		globalFutures = futures
-	}()
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-			func() {
-				ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
-				defer cancel()
-				futures, err := pkg.FetchAllFutures(ctx, c.EliteLogin)
-				if err != nil {
-					slog.Error("fetch all futures err", "err", err)
-					return
-				}
-				globalFutures = futures
-				slog.Info("fetch all futures success")
-			}()
-		}
-	}()
-	// fetch news and blogs
-	func() {
-		news, blogs, err := pkg.FetchAndParseNewsAndBlogs(context.Background(), c.EliteLogin)
-		if err != nil {
+	}
+	if c.RapidAPIProxySecret != "" {
+		if usageStore, err = pkg.NewUsageStore(c.UsagePath, c.UsageFlush); err != nil {
 			panic(err)
 		}
-		globalNews = news
-		globalBlogs = blogs
-	}()
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-			func() {
-				ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
-				defer cancel()
-				news, blogs, err := pkg.FetchAndParseNewsAndBlogs(ctx, c.EliteLogin)
-				if err != nil {
-					slog.Error("fetch all news and blogs err", "err", err)
-					return
-				}
-				globalNews = news
-				globalBlogs = blogs
-				slog.Info("fetch all news and blogs success")
-			}()
-		}
-	}()
+	}
 }
 
-func main() {
+func newRouter() chi.Router {
 	r := chi.NewRouter()
-	r.Use(middleware.Timeout(c.Timeout))
-	r.Use(middleware.Throttle(c.Throttle))
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
+	r.Use(otelhttp.NewMiddleware("finviz-proxy"))
+	r.Use(requestID)
+	r.Use(logRequests)
+	r.Use(instrument)
+	r.Use(timeout(c.Timeout))
+	r.Use(traceWait("throttle", throttle(c.Throttle)))
+	r.Use(recoverer)
+	r.NotFound(notFound)
+	r.MethodNotAllowed(methodNotAllowed)
+
+	// data apis require the rapidapi proxy secret and an api key if configured
+	api := r.With(rapidAPIOnly, apiKeyAuth)
+	cheap := api.With(rateLimit("cheap", c.CheapRate, c.CheapBurst))
+	expensive := api.With(rateLimit("expensive", c.ExpensiveRate, c.ExpensiveBurst))
 
 	/*
 		stock screener apis
 	*/
 
-	r.Get(
+	paramsLoaded := requireLoaded("params", &paramsStore)
+	cheap.With(paramsLoaded).Get(
 		"/params", func(w http.ResponseWriter, r *http.Request) {
-			render.JSON(w, r, globalParams)
+			snapshot := paramsStore.Load()
+			warnStale(w, snapshot.Stale)
+			writeCacheable(w, r, snapshot.Data, snapshot.FetchedAt, untilNextRefresh(jobParams))
 		},
 	)
-	r.Get(
+	expensive.With(paramsLoaded).Get(
 		"/table", func(w http.ResponseWriter, r *http.Request) {
-			params, err := pkg.ParseTableParams(globalParams, r.URL.Query())
+			params, err := pkg.ParseTableParams(paramsStore.Load().Data, r.URL.Query())
 			if err != nil {
-				slog.Error("parse table params", "err", err)
-				render.Status(r, http.StatusBadRequest)
+				slog.WarnContext(r.Context(), "parse table params", "err", err)
 				if pkg.IsParamsError(err) {
-					render.JSON(w, r, err)
+					writeProblem(w, r, pkg.ParamsProblem(err))
 				} else {
-					render.PlainText(w, r, err.Error())
+					problem(w, r, http.StatusBadRequest, pkg.CodeInvalidParams, err.Error())
 				}
 				return
 			}
-			uri := params.BuildUri()
-			slog.Info("to fetch page", "uri", uri)
-			// check cache
-			if table, found := tableCache.Get(uri); found {
-				render.JSON(w, r, table)
-				return
-			}
-			// fetch page and parse table
-			table, err := pkg.FetchPageAndParseTable(r.Context(), uri, c.EliteLogin)
-			if err != nil {
-				slog.Error("fetch page and parse table", "err", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(err.Error()))
-				return
-			}
-			// cache table
-			tableCache.Set(uri, table, cache.DefaultExpiration)
-			render.JSON(w, r, table)
+			serveTable(w, r, params, false)
 		},
 	)
-	r.Post(
+	expensive.With(paramsLoaded).Post(
 		"/table_v2", func(w http.ResponseWriter, r *http.Request) {
-			params, err := pkg.ParseTableParamsV2(globalParams, r.Body)
+			params, err := pkg.ParseTableParamsV2(r.Context(), paramsStore.Load().Data, r.Body, c.EliteLogin)
 			defer r.Body.Close()
 			if err != nil {
-				slog.Error("parse table params v2", "err", err)
-				render.Status(r, http.StatusBadRequest)
+				slog.WarnContext(r.Context(), "parse table params v2", "err", err)
 				if pkg.IsParamsError(err) {
-					render.JSON(w, r, err)
+					writeProblem(w, r, pkg.ParamsProblem(err))
 				} else {
-					render.PlainText(w, r, err.Error())
+					problem(w, r, http.StatusBadRequest, pkg.CodeInvalidParams, err.Error())
 				}
 				return
 			}
-			uri := params.BuildUri()
-			slog.Info("to fetch page", "uri", uri)
-			// check cache
-			if table, found := tableCache.Get(uri); found {
-				render.JSON(w, r, table)
-				return
-			}
-			// fetch page and parse table
-			table, err := pkg.FetchPageAndParseTable(r.Context(), uri, c.EliteLogin)
-			if err != nil {
-				slog.Error("fetch page and parse table", "err", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(err.Error()))
-				return
-			}
-			// cache table
-			tableCache.Set(uri, table, cache.DefaultExpiration)
-			render.JSON(w, r, table)
+			serveTable(w, r, params, true)
 		},
 	)
 
@@ -239,18 +208,20 @@ func main() {
 		futures apis
 	*/
 
-	r.Get("/futures/all", func(w http.ResponseWriter, r *http.Request) {
-		render.JSON(w, r, globalFutures)
+	futuresLoaded := requireLoaded("futures", &futuresStore)
+	cheap.With(futuresLoaded).Get("/futures/all", func(w http.ResponseWriter, r *http.Request) {
+		snapshot := futuresStore.Load()
+		warnStale(w, snapshot.Stale)
+		writeCacheable(w, r, snapshot.Data, snapshot.FetchedAt, untilNextRefresh(jobFutures))
 	})
 
-	r.Post("/futures", func(w http.ResponseWriter, r *http.Request) {
+	cheap.With(futuresLoaded).Post("/futures", func(w http.ResponseWriter, r *http.Request) {
 		symbols := struct {
 			Symbols []string `json:"symbols"`
 		}{}
 		if err := json.NewDecoder(r.Body).Decode(&symbols); err != nil {
-			slog.Error("parse futures json", "err", err)
-			render.Status(r, http.StatusBadRequest)
-			render.PlainText(w, r, `request body should be as: {"symbols": [...]}`)
+			slog.WarnContext(r.Context(), "parse futures json", "err", err)
+			writeProblem(w, r, pkg.ParamsProblem(pkg.NewParamsError(pkg.CodeInvalidBody, "body", err.Error())))
 			return
 		}
 		defer r.Body.Close()
@@ -258,9 +229,11 @@ func main() {
 		ret := struct {
 			Futures []pkg.FutureQuota `json:"futures"`
 		}{}
-		for _, symbol := range symbols.Symbols {
+		futures := futuresStore.Load().Data
+		var unknown pkg.ParamsErrors
+		for i, symbol := range symbols.Symbols {
 			flag := false
-			for _, v := range globalFutures {
+			for _, v := range futures {
 				if v.Label == symbol {
 					ret.Futures = append(ret.Futures, v)
 					flag = true
@@ -268,12 +241,14 @@ func main() {
 				}
 			}
 			if !flag {
-				slog.Error("can't find symbol in all futures", "symbol", symbol)
-				render.Status(r, http.StatusBadRequest)
-				render.PlainText(w, r, "can't find symbol: "+symbol)
-				return
+				unknown = append(unknown, pkg.NewParamsError(pkg.CodeUnknownSymbol, fmt.Sprintf("symbols[%d]", i), symbol))
 			}
 		}
+		if len(unknown) > 0 {
+			slog.WarnContext(r.Context(), "can't find symbols in all futures", "err", unknown)
+			writeProblem(w, r, pkg.ParamsProblem(unknown))
+			return
+		}
 		render.JSON(w, r, ret)
 	})
 
@@ -281,24 +256,173 @@ func main() {
 		news and blogs api
 	*/
 
-	r.Get("/news", func(w http.ResponseWriter, r *http.Request) {
+	newsLoaded := requireLoaded("news and blogs", &newsStore)
+	cheap.With(newsLoaded).Get("/news", func(w http.ResponseWriter, r *http.Request) {
+		snapshot := newsStore.Load()
 		ret := struct {
 			News []pkg.Record `json:"news"`
 		}{}
-		ret.News = globalNews
-		render.JSON(w, r, ret)
+		ret.News = snapshot.Data.News
+		warnStale(w, snapshot.Stale)
+		writeCacheable(w, r, ret, snapshot.FetchedAt, untilNextRefresh(jobNews))
 	})
 
-	r.Get("/blogs", func(w http.ResponseWriter, r *http.Request) {
+	cheap.With(newsLoaded).Get("/blogs", func(w http.ResponseWriter, r *http.Request) {
+		snapshot := newsStore.Load()
 		ret := struct {
 			Blogs []pkg.Record `json:"blogs"`
 		}{}
-		ret.Blogs = globalBlogs
+		ret.Blogs = snapshot.Data.Blogs
+		warnStale(w, snapshot.Stale)
+		writeCacheable(w, r, ret, snapshot.FetchedAt, untilNextRefresh(jobNews))
+	})
+
+	/*
+		market api
+	*/
+
+	cheap.Get("/market/status", func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, r, pkg.MarketStatusAt(time.Now()))
+	})
+
+	/*
+		status api
+	*/
+
+	r.Get("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ready, loaded := readiness()
+		if !ready {
+			render.Status(r, http.StatusServiceUnavailable)
+		}
+		render.JSON(w, r, map[string]any{"ready": ready, "loaded": loaded})
+	})
+
+	// metrics and status expose api key names and internals, so they are admin only like /admin
+	r.With(adminOnly).Handle("/metrics", promhttp.Handler())
+
+	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, r, map[string]string{"status": "ok"})
+	})
+
+	r.With(adminOnly).Get("/status", func(w http.ResponseWriter, r *http.Request) {
+		ret := struct {
+			Datasets []datasetStatus     `json:"datasets"`
+			Elite    eliteStatus         `json:"elite"`
+			Cache    *pkg.CacheStats     `json:"cache,omitempty"`
+			Breakers []pkg.BreakerStatus `json:"breakers"`
+		}{}
+		ret.Datasets = datasetStatuses()
+		ret.Elite = eliteLoginStatus()
+		// the full stats scan the store, and are left to /admin/cache
+		stats := tableCache.Counters(r.Context())
+		ret.Cache = &stats
+		ret.Breakers = pkg.BreakerStatuses()
 		render.JSON(w, r, ret)
 	})
 
-	// start serve
-	addr := ":" + strconv.Itoa(c.Port)
-	slog.Info("Listening on", "addr", addr)
-	http.ListenAndServe(addr, r)
+	/*
+		admin apis
+	*/
+
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(adminOnly)
+		r.Get("/cache", func(w http.ResponseWriter, r *http.Request) {
+			stats, err := tableCache.Stats(r.Context())
+			if err != nil {
+				slog.ErrorContext(r.Context(), "get cache stats", "err", err)
+				internalProblem(w, r, err)
+				return
+			}
+			render.JSON(w, r, stats)
+		})
+		r.Delete("/cache", func(w http.ResponseWriter, r *http.Request) {
+			if err := tableCache.Purge(r.Context()); err != nil {
+				slog.ErrorContext(r.Context(), "purge cache", "err", err)
+				internalProblem(w, r, err)
+				return
+			}
+			slog.InfoContext(r.Context(), "table cache purged")
+			render.NoContent(w, r)
+		})
+		r.Post("/refresh/{job}", func(w http.ResponseWriter, r *http.Request) {
+			job := chi.URLParam(r, "job")
+			if err := scheduler.Trigger(job); err != nil {
+				problem(w, r, http.StatusNotFound, pkg.CodeNotFound, err.Error())
+				return
+			}
+			slog.InfoContext(r.Context(), "refresh triggered", "job", job)
+			render.Status(r, http.StatusAccepted)
+			render.JSON(w, r, map[string]string{"job": job})
+		})
+		r.Get("/usage", func(w http.ResponseWriter, r *http.Request) {
+			if usageStore == nil {
+				problem(w, r, http.StatusNotFound, pkg.CodeNotFound, "usage is recorded only with RAPIDAPIPROXYSECRET")
+				return
+			}
+			// days default to today, in UTC as recorded
+			today := time.Now().UTC().Format(time.DateOnly)
+			from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+			if from == "" {
+				from = today
+			}
+			if to == "" {
+				to = today
+			}
+			var paramsErrors pkg.ParamsErrors
+			if _, err := time.Parse(time.DateOnly, from); err != nil {
+				paramsErrors = append(paramsErrors, pkg.NewParamsError(pkg.CodeInvalidParams, "from", from))
+			}
+			if _, err := time.Parse(time.DateOnly, to); err != nil {
+				paramsErrors = append(paramsErrors, pkg.NewParamsError(pkg.CodeInvalidParams, "to", to))
+			}
+			if len(paramsErrors) > 0 {
+				writeProblem(w, r, pkg.ParamsProblem(paramsErrors))
+				return
+			}
+			if err := usageStore.Flush(); err != nil {
+				slog.ErrorContext(r.Context(), "flush usage", "err", err)
+			}
+			usage, err := usageStore.Usage(r.URL.Query().Get("user"), from, to)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "get usage", "err", err)
+				internalProblem(w, r, err)
+				return
+			}
+			render.JSON(w, r, map[string]any{"from": from, "to": to, "usage": usage})
+		})
+	})
+
+	return r
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx); err != nil {
+		slog.Error("server exited", "err", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context) error {
+	if c.OtlpEndpoint != "" {
+		exporter, err := newTraceExporter(ctx)
+		if err != nil {
+			return err
+		}
+		provider := setupTracing(exporter, c.TraceSampleRatio)
+		defer func() {
+			// flush pending spans, ctx is already done here
+			ctx, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout)
+			defer cancel()
+			if err := provider.Shutdown(ctx); err != nil {
+				slog.ErrorContext(ctx, "failed to flush traces", "err", err)
+			}
+		}()
+	}
+	if keyStore != nil {
+		go keyStore.Watch(ctx, c.APIKeysReload)
+	}
+	server := &http.Server{Addr: ":" + strconv.Itoa(c.Port), Handler: newRouter()}
+	return serve(ctx, server)
 }
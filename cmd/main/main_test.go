@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ppaanngggg/finviz-proxy/pkg"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Test_concurrentRefreshAndRequests is meant to run with -race.
+func Test_concurrentRefreshAndRequests(t *testing.T) {
+	refresh := func(i int) {
+		paramsStore.Store(&pkg.Params{
+			Sorters: []pkg.Sorter{{Name: "Ticker", Value: "ticker"}},
+		}, time.Now(), false)
+		futuresStore.Store(map[string]pkg.FutureQuota{
+			"ES": {Label: "S&P 500", Ticker: "ES", Last: float64(i)},
+		}, time.Now(), false)
+		newsStore.Store(&newsAndBlogs{
+			News:  []pkg.Record{{Title: "news"}},
+			Blogs: []pkg.Record{{Title: "blog"}},
+		}, time.Now(), false)
+	}
+	refresh(0)
+	// all requests come from the same client
+	c.CheapRate = 0
+	defer func() { c.CheapRate = 20 }()
+	router := newRouter()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 200; i++ {
+			refresh(i)
+		}
+	}()
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				for _, req := range []*http.Request{
+					httptest.NewRequest(http.MethodGet, "/params", nil),
+					httptest.NewRequest(http.MethodGet, "/futures/all", nil),
+					httptest.NewRequest(http.MethodPost, "/futures", strings.NewReader(`{"symbols": ["S&P 500"]}`)),
+					httptest.NewRequest(http.MethodGet, "/news", nil),
+					httptest.NewRequest(http.MethodGet, "/blogs", nil),
+					httptest.NewRequest(http.MethodGet, "/readyz", nil),
+				} {
+					w := httptest.NewRecorder()
+					router.ServeHTTP(w, req)
+					assert.Equal(t, http.StatusOK, w.Code, req.URL.Path)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func Test_serve(t *testing.T) {
+	defer func() { revalidations = pkg.NewTaskGroup() }()
+	// a listener error is returned
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer occupied.Close()
+	err = serve(context.Background(), &http.Server{Addr: occupied.Addr().String()})
+	assert.Error(t, err)
+
+	// in-flight requests are drained on shutdown
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := free.Addr().String()
+	free.Close()
+	started := make(chan struct{})
+	server := &http.Server{Addr: addr, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, server)
+	}()
+	var resp *http.Response
+	requested := make(chan error, 1)
+	go func() {
+		for i := 0; i < 50; i++ {
+			if resp, err = http.Get("http://" + addr); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		requested <- err
+	}()
+	<-started
+	cancel()
+	assert.NoError(t, <-requested)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "done", string(body))
+	assert.NoError(t, <-served)
+	// no revalidation is started after shutdown, as the cache is closed
+	assert.False(t, revalidations.Go(func(ctx context.Context) {}))
+}
+
+func Test_status(t *testing.T) {
+	c.AdminToken = "admin"
+	defer func() { c.AdminToken = "" }()
+	router := newRouter()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	paramsStore.Store(&pkg.Params{}, time.Now(), true)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	status := struct {
+		Datasets []datasetStatus `json:"datasets"`
+		Elite    eliteStatus     `json:"elite"`
+		Cache    *pkg.CacheStats `json:"cache"`
+	}{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Len(t, status.Datasets, 3)
+	assert.Equal(t, jobParams, status.Datasets[0].Name)
+	assert.True(t, status.Datasets[0].Loaded)
+	assert.True(t, status.Datasets[0].Stale)
+	assert.Equal(t, pkg.EndpointScreener, status.Datasets[0].Upstream.Endpoint)
+	assert.Equal(t, c.EliteLogin, status.Elite.Enabled)
+	assert.NotNil(t, status.Cache)
+}
+
+func Test_metrics(t *testing.T) {
+	c.AdminToken = "admin"
+	defer func() { c.AdminToken = "" }()
+	router := newRouter()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `finviz_proxy_http_requests_total{code="200",method="GET",route="/healthz"}`)
+	assert.Contains(t, w.Body.String(), `finviz_proxy_http_request_duration_seconds_count{code="200",method="GET",route="/healthz"}`)
+}
+
+func Test_tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := setupTracing(exporter, 1)
+	defer provider.Shutdown(context.Background())
+	paramsStore.Store(&pkg.Params{}, time.Now(), false)
+	router := newRouter()
+
+	req := httptest.NewRequest(http.MethodGet, "/params", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, provider.ForceFlush(context.Background()))
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	server, ok := spans["GET /params"]
+	assert.True(t, ok)
+	// the incoming trace context is continued
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID().String())
+	throttle, ok := spans["throttle"]
+	assert.True(t, ok)
+	assert.Equal(t, server.SpanContext.SpanID(), throttle.Parent.SpanID())
+}
+
+func Test_requestID(t *testing.T) {
+	router := newRouter()
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set(requestIDHeader, "abc-123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "abc-123", w.Header().Get(requestIDHeader))
+
+	// invalid IDs are replaced
+	req = httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set(requestIDHeader, "bad id\n")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Len(t, w.Header().Get(requestIDHeader), 16)
+}
+
+func Test_apiKeyAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"keys": [{"name": "team-a", "key": "key-a", "rate": 0.001, "burst": 1}]}`), 0644))
+	store, err := pkg.NewKeyStore(path)
+	assert.NoError(t, err)
+	keyStore = store
+	defer func() { keyStore = nil }()
+	router := newRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/market/status", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/market/status?auth=key-a", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+
+	req := httptest.NewRequest(http.MethodGet, "/market/status", nil)
+	req.Header.Set("X-API-Key", "key-a")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// operational apis don't require api keys
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func Test_rapidAPIOnly(t *testing.T) {
+	c.RapidAPIProxySecret, c.AdminToken = "secret", "admin"
+	store, err := pkg.NewUsageStore(filepath.Join(t.TempDir(), "usage.db"), time.Hour)
+	assert.NoError(t, err)
+	usageStore = store
+	defer func() {
+		c.RapidAPIProxySecret, c.AdminToken = "", ""
+		usageStore.Close()
+		usageStore = nil
+	}()
+	router := newRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/market/status", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/market/status", nil)
+		req.Header.Set(rapidAPISecretHeader, "secret")
+		req.Header.Set(rapidAPIUserHeader, "alice")
+		req.Header.Set(rapidAPISubscriptionHeader, "BASIC")
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/usage?user=alice", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	ret := struct {
+		Usage []pkg.UsageRecord `json:"usage"`
+	}{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &ret))
+	assert.Equal(t, []pkg.UsageRecord{{
+		Day: time.Now().UTC().Format(time.DateOnly), User: "alice", Subscription: "BASIC",
+		Endpoint: "/market/status", Requests: 2,
+	}}, ret.Usage)
+}
+
+func Test_rateLimit(t *testing.T) {
+	c.ExpensiveRate, c.ExpensiveBurst = 0.001, 1
+	defer func() { c.ExpensiveRate, c.ExpensiveBurst = 1, 5 }()
+	paramsStore.Store(&pkg.Params{}, time.Now(), false)
+	router := newRouter()
+
+	// the first request fails validation, but takes a token
+	req := httptest.NewRequest(http.MethodGet, "/table?order=unknown", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/table?order=unknown", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// other clients and cheap routes are not affected
+	req = httptest.NewRequest(http.MethodGet, "/table?order=unknown", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/params", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func Test_problems(t *testing.T) {
+	paramsStore.Store(&pkg.Params{Sorters: []pkg.Sorter{{Name: "Ticker", Value: "ticker"}}}, time.Now(), false)
+	futuresStore.Store(map[string]pkg.FutureQuota{"ES": {Label: "S&P 500", Ticker: "ES"}}, time.Now(), false)
+	router := newRouter()
+
+	for _, tc := range []struct {
+		req    *http.Request
+		status int
+		code   string
+		errors int
+	}{
+		{httptest.NewRequest(http.MethodGet, "/table?order=unknown&desc=maybe", nil), http.StatusBadRequest, pkg.CodeInvalidParams, 2},
+		{httptest.NewRequest(http.MethodPost, "/table_v2", strings.NewReader(`{`)), http.StatusBadRequest, pkg.CodeInvalidParams, 1},
+		{httptest.NewRequest(http.MethodPost, "/futures", strings.NewReader(`{"symbols": ["S&P 500", "A", "B"]}`)), http.StatusBadRequest, pkg.CodeInvalidParams, 2},
+		{httptest.NewRequest(http.MethodGet, "/unknown", nil), http.StatusNotFound, pkg.CodeNotFound, 0},
+		{httptest.NewRequest(http.MethodDelete, "/params", nil), http.StatusMethodNotAllowed, pkg.CodeMethodNotAllowed, 0},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, tc.req)
+		assert.Equal(t, tc.status, w.Code, tc.req.URL.Path)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+		problem := pkg.Problem{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		assert.Equal(t, tc.status, problem.Status)
+		assert.Equal(t, tc.code, problem.Code)
+		assert.Equal(t, "urn:finviz-proxy:problem:"+tc.code, problem.Type)
+		assert.Equal(t, tc.req.URL.Path, problem.Instance)
+		assert.Len(t, problem.Errors, tc.errors, tc.req.URL.Path)
+	}
+}
+
+func Test_tableV2EchoesQuery(t *testing.T) {
+	paramsStore.Store(&pkg.Params{
+		Filters: []pkg.Filter{{Id: "fs_exch", Name: "Exchange", Options: []pkg.FilterOption{{Name: "NASDAQ", Value: "exch_nasd"}}}},
+		Sorters: []pkg.Sorter{{Name: "Price", Value: "price"}},
+	}, time.Now(), false)
+	params := &pkg.TableParams{Order: "price", Filters: []string{"exch_nasd"}}
+	tableCache.Set(context.Background(), params.CacheKey(c.EliteLogin), &pkg.Table{Headers: []string{"Ticker"}})
+	router := newRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/table_v2", strings.NewReader(
+		`{"order": "price", "filters": {"exchange": "Nasdaq"}}`,
+	)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	ret := struct {
+		Query   pkg.TableParams `json:"query"`
+		Headers []string        `json:"headers"`
+	}{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &ret))
+	assert.Equal(t, *params, ret.Query)
+	assert.Equal(t, []string{"Ticker"}, ret.Headers)
+}
+
+func Test_tableTickerChunks(t *testing.T) {
+	paramsStore.Store(&pkg.Params{}, time.Now(), false)
+	tickers := make([]string, 25)
+	for i := range tickers {
+		tickers[i] = fmt.Sprintf("T%d", i)
+	}
+	// both chunks are cached, listed in reverse order
+	for _, chunk := range (&pkg.TableParams{Order: "ticker", Tickers: tickers}).Chunks() {
+		table := &pkg.Table{Headers: []string{"No.", "Ticker"}}
+		for i := len(chunk.Tickers) - 1; i >= 0; i-- {
+			table.Rows = append(table.Rows, []string{"0", chunk.Tickers[i]})
+		}
+		tableCache.Set(context.Background(), chunk.CacheKey(c.EliteLogin), table)
+	}
+	router := newRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/table?tickers="+strings.Join(tickers, ","), nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	table := pkg.Table{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &table))
+	assert.Len(t, table.Rows, len(tickers))
+	for i, row := range table.Rows {
+		assert.Equal(t, []string{strconv.Itoa(i + 1), tickers[i]}, row)
+	}
+	assert.Equal(t, string(pkg.CacheFresh), w.Header().Get("X-Cache"))
+	// each chunk takes a token of the expensive routes
+	assert.Equal(t, strconv.Itoa(c.ExpensiveBurst-2), w.Header().Get("X-RateLimit-Remaining"))
+
+	// a single chunk is in the order of tickers too, instead of the order of finviz
+	single := &pkg.TableParams{Order: "ticker", Tickers: []string{"T24", "T20", "T22", "T21", "T23"}}
+	tableCache.Set(context.Background(), single.CacheKey(c.EliteLogin), &pkg.Table{
+		Headers: []string{"No.", "Ticker"},
+		Rows:    [][]string{{"1", "T20"}, {"2", "T21"}, {"3", "T22"}, {"4", "T23"}, {"5", "T24"}},
+	})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/table?tickers=T24,T20,T22,T21,T23", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	table = pkg.Table{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &table))
+	assert.Equal(t, [][]string{{"1", "T24"}, {"2", "T20"}, {"3", "T22"}, {"4", "T21"}, {"5", "T23"}}, table.Rows)
+}
+
+func Test_triggeredRefreshSkipsCache(t *testing.T) {
+	var fetches atomic.Int32
+	upstreamParams = func(ctx context.Context, isElite bool) (*pkg.Params, error) {
+		fetches.Add(1)
+		return &pkg.Params{}, nil
+	}
+	defer func() { upstreamParams = pkg.FetchParams }()
+
+	scheduler := pkg.NewScheduler()
+	assert.NoError(t, scheduler.Register(pkg.Job{
+		Name: jobParams, Intervals: pkg.Intervals{pkg.PhaseDefault: time.Hour}, Run: refreshParams,
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go scheduler.Run(ctx)
+	assert.Eventually(t, func() bool { return fetches.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	// the dataset is cached well within its interval, but a triggered refresh fetches it again
+	version := paramsStore.Load().Version
+	assert.NoError(t, scheduler.Trigger(jobParams))
+	assert.Eventually(t, func() bool { return fetches.Load() == 2 }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return paramsStore.Load().Version > version }, time.Second, 10*time.Millisecond)
+}
+
+func Test_middlewareProblems(t *testing.T) {
+	release := make(chan struct{})
+	blocked := make(chan struct{})
+	for _, tc := range []struct {
+		name    string
+		handler http.Handler
+		status  int
+		code    string
+	}{
+		{"timeout", timeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		})), http.StatusGatewayTimeout, pkg.CodeUpstreamTimeout},
+		{"panic", recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		})), http.StatusInternalServerError, pkg.CodeInternalError},
+		{"throttle", func() http.Handler {
+			h := throttle(1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(blocked)
+				<-release
+			}))
+			// the only slot is taken by a pending request
+			go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			<-blocked
+			return h
+		}(), http.StatusTooManyRequests, pkg.CodeRateLimited},
+	} {
+		w := httptest.NewRecorder()
+		tc.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, tc.status, w.Code, tc.name)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"), tc.name)
+		problem := pkg.Problem{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem), tc.name)
+		assert.Equal(t, tc.code, problem.Code, tc.name)
+	}
+	close(release)
+}
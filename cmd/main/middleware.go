@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/pkg/errors"
+	"github.com/ppaanngggg/finviz-proxy/pkg"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// The middlewares below replace middleware.Throttle, middleware.Timeout and middleware.Recoverer of chi,
+// which answer with plain text or empty bodies instead of problems.
+
+// throttle serves at most limit requests at once, and rejects the others with 429 right away.
+func throttle(limit int) func(http.Handler) http.Handler {
+	tokens := make(chan struct{}, limit)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case tokens <- struct{}{}:
+				defer func() { <-tokens }()
+				next.ServeHTTP(w, r)
+			default:
+				w.Header().Set("Retry-After", "1")
+				problem(w, r, http.StatusTooManyRequests, pkg.CodeRateLimited, "server is at capacity")
+			}
+		})
+	}
+}
+
+// timeout cancels the context of requests after d, and answers 504 if the handler gave up without writing.
+func timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && ww.Status() == 0 {
+				problem(w, r, http.StatusGatewayTimeout, pkg.CodeUpstreamTimeout, "request timed out")
+			}
+		})
+	}
+}
+
+// recoverer logs panics of handlers with their stack, and answers 500.
+func recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+			if rvr == http.ErrAbortHandler {
+				panic(rvr) // let net/http abort the response
+			}
+			slog.ErrorContext(r.Context(), "panic", "panic", rvr, "stack", string(debug.Stack()))
+			if r.Header.Get("Connection") != "Upgrade" {
+				problem(w, r, http.StatusInternalServerError, pkg.CodeInternalError, "")
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
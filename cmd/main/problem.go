@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/ppaanngggg/finviz-proxy/pkg"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// writeProblem writes p as application/problem+json, with the request path as its instance.
+func writeProblem(w http.ResponseWriter, r *http.Request, p *pkg.Problem) {
+	p.Instance = r.URL.Path
+	body, err := json.Marshal(p)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "marshal problem", "err", err)
+		http.Error(w, p.Title, p.Status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	w.Write(body)
+}
+
+func problem(w http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	writeProblem(w, r, pkg.NewProblem(status, code, http.StatusText(status), detail))
+}
+
+// upstreamProblem reports why fetching from finviz failed.
+func upstreamProblem(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case pkg.IsParseError(err):
+		problem(w, r, http.StatusBadGateway, pkg.CodeParseFailed, "failed to parse the finviz page")
+	case pkg.IsCircuitOpen(err):
+		w.Header().Set("Retry-After", strconv.Itoa(int(c.BreakerCooldown.Seconds())))
+		problem(w, r, http.StatusServiceUnavailable, pkg.CodeUpstreamUnavailable, "finviz is unavailable, retry later")
+	case errors.Is(err, context.DeadlineExceeded):
+		problem(w, r, http.StatusGatewayTimeout, pkg.CodeUpstreamTimeout, "finviz didn't respond in time")
+	default:
+		problem(w, r, http.StatusBadGateway, pkg.CodeUpstreamUnavailable, "failed to fetch from finviz")
+	}
+}
+
+func internalProblem(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "internal error", "err", err)
+	problem(w, r, http.StatusInternalServerError, pkg.CodeInternalError, "")
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	problem(w, r, http.StatusNotFound, pkg.CodeNotFound, "")
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	problem(w, r, http.StatusMethodNotAllowed, pkg.CodeMethodNotAllowed, r.Method+" is not allowed")
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/subtle"
+	"github.com/go-chi/chi/v5"
+	"github.com/ppaanngggg/finviz-proxy/pkg"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	rapidAPISecretHeader       = "X-RapidAPI-Proxy-Secret"
+	rapidAPIUserHeader         = "X-RapidAPI-User"
+	rapidAPISubscriptionHeader = "X-RapidAPI-Subscription"
+)
+
+// rapidAPIOnly rejects requests not coming through the RapidAPI gateway, and records the usage of each subscriber,
+// if the proxy secret is configured.
+func rapidAPIOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.RapidAPIProxySecret == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		secret := r.Header.Get(rapidAPISecretHeader)
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(c.RapidAPIProxySecret)) != 1 {
+			slog.WarnContext(r.Context(), "request bypassing rapidapi", "path", r.URL.Path, "remote", r.RemoteAddr)
+			problem(w, r, http.StatusForbidden, pkg.CodeForbidden, "requests must come through RapidAPI")
+			return
+		}
+		user := r.Header.Get(rapidAPIUserHeader)
+		subscription := r.Header.Get(rapidAPISubscriptionHeader)
+		slog.DebugContext(r.Context(), "rapidapi request", "user", user, "subscription", subscription)
+		if usageStore != nil {
+			endpoint := r.URL.Path
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				endpoint = rctx.RoutePattern()
+			}
+			usageStore.Record(user, subscription, endpoint, time.Now())
+		}
+		if user != "" {
+			r = withClient(r, "rapidapi:"+user)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
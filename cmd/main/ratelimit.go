@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"github.com/ppaanngggg/finviz-proxy/pkg"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "finviz_proxy_rate_limited_total",
+	Help: "Requests rejected by the per client rate limit, by route class.",
+}, []string{"class"})
+
+type clientKey struct{}
+
+type chargeKey struct{}
+
+// charge takes n more tokens from the route class limit of the client, for requests costing more than one,
+// and updates the X-RateLimit headers. It does nothing if the route has no limit.
+func charge(w http.ResponseWriter, r *http.Request, n int) {
+	charge, ok := r.Context().Value(chargeKey{}).(func(n int) (int, time.Duration))
+	if !ok || n <= 0 {
+		return
+	}
+	remaining, wait := charge(n)
+	if current, err := strconv.Atoi(w.Header().Get("X-RateLimit-Remaining")); err != nil || remaining < current {
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", seconds(wait))
+	}
+}
+
+// withClient identifies the client of the request, as an api key or a rapidapi user, instead of its ip.
+func withClient(r *http.Request, client string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientKey{}, client))
+}
+
+// clientOf returns who is calling, the api key name or rapidapi user if known, otherwise the ip.
+func clientOf(r *http.Request) string {
+	if client, ok := r.Context().Value(clientKey{}).(string); ok {
+		return client
+	}
+	if c.TrustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return "ip:" + strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimit limits each client to rate requests per second with bursts of burst, on the routes of class.
+// It is disabled if rate is not positive.
+func rateLimit(class string, rate float64, burst int) func(http.Handler) http.Handler {
+	if rate <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	limiter := pkg.NewRateLimiter(rate, burst)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := clientOf(r)
+			ok, remaining, wait := limiter.Allow(client)
+			// report the stricter of this limit and the limit of the api key
+			if current, err := strconv.Atoi(w.Header().Get("X-RateLimit-Remaining")); err != nil || remaining < current || !ok {
+				w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limiter.Burst()))
+				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+				w.Header().Set("X-RateLimit-Reset", seconds(wait))
+			}
+			if !ok {
+				slog.WarnContext(r.Context(), "client rate limited", "client", client, "class", class)
+				rateLimited.WithLabelValues(class).Inc()
+				w.Header().Set("Retry-After", seconds(wait))
+				problem(w, r, http.StatusTooManyRequests, pkg.CodeRateLimited, "rate limit of "+class+" routes exceeded")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), chargeKey{}, func(n int) (int, time.Duration) {
+				return limiter.Charge(client, n)
+			})))
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/ppaanngggg/finviz-proxy/pkg"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	jobLogin   = "login"
+	jobParams  = "params"
+	jobFutures = "futures"
+	jobNews    = "news"
+	jobTables  = "tables"
+)
+
+// registerJobs registers the elite login and the datasets refreshes, datasets are loaded after login.
+func registerJobs() error {
+	after := ""
+	if c.EliteLogin {
+		after = jobLogin
+		err := scheduler.Register(pkg.Job{
+			Name:      jobLogin,
+			Intervals: pkg.Intervals{pkg.PhaseDefault: c.LoginInterval},
+			Timeout:   c.Timeout,
+			Backoff:   c.RefreshBackoff,
+			Run:       eliteLogin,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, job := range []pkg.Job{
+		{Name: jobParams, Intervals: c.ParamsIntervals, Run: refreshParams},
+		{Name: jobFutures, Intervals: c.FuturesIntervals, Run: refreshFutures},
+		{Name: jobNews, Intervals: c.NewsIntervals, Run: refreshNewsAndBlogs},
+	} {
+		job.Jitter, job.Timeout, job.Backoff, job.After = c.RefreshJitter, c.Timeout, c.RefreshBackoff, after
+		if err := scheduler.Register(job); err != nil {
+			return err
+		}
+	}
+	// refresh hot tables before they go stale
+	if c.CacheHotHits > 0 {
+		return scheduler.Register(pkg.Job{
+			Name:      jobTables,
+			Intervals: pkg.Intervals{pkg.PhaseDefault: c.CacheTTL / 2},
+			Timeout:   c.Timeout,
+			Run:       refreshHotTables,
+		})
+	}
+	return nil
+}
+
+// untilNextRefresh is how long the current snapshot of job is expected to stay, as max-age.
+func untilNextRefresh(job string) time.Duration {
+	status, ok := scheduler.Status(job)
+	if !ok || status.NextRun.IsZero() {
+		return 0
+	}
+	return max(time.Until(status.NextRun), 0)
+}
+
+func eliteLogin(ctx context.Context) error {
+	ok, err := pkg.EliteLogin(ctx, c.Email, c.Password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("login failed")
+	}
+	eliteLoggedIn.Store(true)
+	return nil
+}
+
+func refreshParams(ctx context.Context) error {
+	params, fetchedAt, err := fetchParams(ctx)
+	if err != nil {
+		return err
+	}
+	params.Index() // built before serving, so requests never wait for it
+	paramsStore.Store(params, fetchedAt, false)
+	saveSnapshot("params", params, fetchedAt)
+	return nil
+}
+
+func refreshFutures(ctx context.Context) error {
+	futures, fetchedAt, err := fetchFutures(ctx)
+	if err != nil {
+		return err
+	}
+	futuresStore.Store(futures, fetchedAt, false)
+	saveSnapshot("futures", futures, fetchedAt)
+	return nil
+}
+
+func refreshNewsAndBlogs(ctx context.Context) error {
+	newsAndBlogs, fetchedAt, err := fetchNewsAndBlogs(ctx)
+	if err != nil {
+		return err
+	}
+	newsStore.Store(newsAndBlogs, fetchedAt, false)
+	saveSnapshot("news", newsAndBlogs, fetchedAt)
+	return nil
+}
+
+// eliteSessionValid reports whether we logged in and the session cookies haven't expired.
+func eliteSessionValid() bool {
+	return eliteLoggedIn.Load() && pkg.HasEliteSession()
+}
+
+// readiness reports which required datasets are loaded, and whether the elite session is valid if enabled.
+func readiness() (bool, map[string]bool) {
+	loaded := map[string]bool{
+		"params":  paramsStore.Loaded(),
+		"futures": futuresStore.Loaded(),
+		"news":    newsStore.Loaded(),
+	}
+	if c.EliteLogin {
+		loaded["login"] = eliteSessionValid()
+	}
+	for _, ok := range loaded {
+		if !ok {
+			return false, loaded
+		}
+	}
+	return true, loaded
+}
+
+// requireLoaded returns 503 until the dataset of the route is loaded.
+func requireLoaded(name string, store interface{ Loaded() bool }) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !store.Loaded() {
+				slog.WarnContext(r.Context(), "dataset not loaded yet", "name", name, "path", r.URL.Path)
+				w.Header().Set("Retry-After", "5")
+				problem(w, r, http.StatusServiceUnavailable, pkg.CodeNotLoaded, name+" not loaded yet")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type datasetStatus struct {
+	pkg.JobStatus
+	Loaded    bool                `json:"loaded"`
+	Version   uint64              `json:"version,omitempty"`
+	FetchedAt *time.Time          `json:"fetchedAt,omitempty"`
+	Stale     bool                `json:"stale"`
+	Upstream  pkg.UpstreamLatency `json:"upstream"`
+}
+
+// datasetStatusOf combines the refresh job, the current snapshot and the upstream endpoint of a dataset.
+func datasetStatusOf[T any](job string, endpoint string, store *pkg.SnapshotStore[T]) datasetStatus {
+	status := datasetStatus{Upstream: pkg.UpstreamLatencyOf(endpoint)}
+	status.JobStatus, _ = scheduler.Status(job)
+	status.Name = job
+	if snapshot := store.Load(); snapshot != nil {
+		status.Loaded = true
+		status.Version = snapshot.Version
+		status.FetchedAt = &snapshot.FetchedAt
+		status.Stale = snapshot.Stale
+	}
+	return status
+}
+
+func datasetStatuses() []datasetStatus {
+	return []datasetStatus{
+		datasetStatusOf(jobParams, pkg.EndpointScreener, &paramsStore),
+		datasetStatusOf(jobFutures, pkg.EndpointFutures, &futuresStore),
+		datasetStatusOf(jobNews, pkg.EndpointNews, &newsStore),
+	}
+}
+
+type eliteStatus struct {
+	Enabled      bool       `json:"enabled"`
+	LoggedIn     bool       `json:"loggedIn"`
+	SessionValid bool       `json:"sessionValid"`
+	LastLogin    *time.Time `json:"lastLogin,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+}
+
+func eliteLoginStatus() eliteStatus {
+	status := eliteStatus{Enabled: c.EliteLogin}
+	if !c.EliteLogin {
+		return status
+	}
+	status.LoggedIn = eliteLoggedIn.Load()
+	status.SessionValid = eliteSessionValid()
+	if job, ok := scheduler.Status(jobLogin); ok {
+		if !job.LastSuccess.IsZero() {
+			status.LastLogin = &job.LastSuccess
+		}
+		status.LastError = job.LastError
+	}
+	return status
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// serve runs the server and background jobs until ctx is done, then drains in-flight requests,
+// stops the jobs and closes the cache store, all within c.ShutdownTimeout.
+// It returns the listener error if the server failed to serve.
+func serve(ctx context.Context, server *http.Server) error {
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+	var jobs sync.WaitGroup
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		if err := scheduler.Run(jobsCtx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.ErrorContext(ctx, "scheduler exited", "err", err)
+		}
+	}()
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return shutdown(server, cancelJobs, &jobs, err)
+	}
+	slog.InfoContext(ctx, "Listening on", "addr", listener.Addr().String())
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	select {
+	case err = <-served:
+		// the server stopped without being asked to
+		return shutdown(server, cancelJobs, &jobs, err)
+	case <-ctx.Done():
+		slog.InfoContext(ctx, "shutting down...", "timeout", c.ShutdownTimeout)
+		return shutdown(server, cancelJobs, &jobs, nil)
+	}
+}
+
+func shutdown(server *http.Server, cancelJobs context.CancelFunc, jobs *sync.WaitGroup, serveErr error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout)
+	defer cancel()
+	// requests being drained serve stale tables without revalidating them
+	revalidations.Stop()
+	// stop accepting connections and wait for in-flight requests
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("failed to drain requests", "err", err)
+	}
+	// stop refreshing, a refresh in progress is canceled through its context
+	cancelJobs()
+	if !waitUntil(ctx, jobs.Wait) {
+		slog.Error("background jobs not stopped before shutdown timeout")
+	}
+	if !revalidations.Shutdown(ctx) {
+		slog.Error("table revalidations canceled at shutdown timeout")
+	}
+	// flush and close the cache, bolt and redis stores release their files and connections
+	if err := cacheStore.Close(); err != nil {
+		slog.Error("failed to close cache store", "err", err)
+	}
+	if usageStore != nil {
+		if err := usageStore.Close(); err != nil {
+			slog.Error("failed to close usage store", "err", err)
+		}
+	}
+	if serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+	slog.Info("shutdown complete")
+	return nil
+}
+
+// waitUntil calls wait and returns true if it returned before ctx is done.
+func waitUntil(ctx context.Context, wait func()) bool {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ppaanngggg/finviz-proxy/pkg"
+	"github.com/redis/go-redis/v9"
+)
+
+func newCacheStore() (pkg.CacheStore, error) {
+	switch c.CacheBackend {
+	case "memory":
+		return pkg.NewMemoryStore(pkg.CacheLimits{
+			MaxEntries: c.CacheMaxEntries,
+			MaxBytes:   c.CacheMaxBytes,
+			Eviction:   pkg.EvictionPolicy(c.CacheEviction),
+		}), nil
+	case "bolt":
+		return pkg.NewBoltStore(c.CachePath)
+	case "redis":
+		ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+		defer cancel()
+		return pkg.NewRedisStore(ctx, &redis.Options{
+			Addr:     c.RedisAddr,
+			Password: c.RedisPassword,
+			DB:       c.RedisDB,
+		}, c.RedisPrefix)
+	}
+	return nil, fmt.Errorf("unknown cache backend: %s", c.CacheBackend)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/ppaanngggg/finviz-proxy/pkg"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fetchTable fetches and caches the table of key, concurrent calls of the same key share one fetch.
+func fetchTable(ctx context.Context, key string) (*pkg.CachedTable, error, bool) {
+	return tableGroup.Do(ctx, key, func(ctx context.Context) (*pkg.CachedTable, error) {
+		ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+		// key is the uri scoped by session, see pkg.TableParams.CacheKey
+		_, uri, _ := strings.Cut(key, ":")
+		table, err := pkg.FetchPageAndParseTable(ctx, uri, c.EliteLogin)
+		if err != nil {
+			return nil, err
+		}
+		return tableCache.Set(ctx, key, table), nil
+	})
+}
+
+// revalidations runs background revalidations, shutdown stops starting them and waits for or cancels them
+// before closing the cache.
+var revalidations = pkg.NewTaskGroup()
+
+func revalidateTable(key string) {
+	revalidations.Go(func(ctx context.Context) {
+		if _, err, _ := fetchTable(ctx, key); err != nil {
+			slog.ErrorContext(ctx, "revalidate table", "key", key, "err", err)
+		}
+	})
+}
+
+// refreshHotTables refreshes frequently requested tables before they go stale.
+func refreshHotTables(ctx context.Context) error {
+	for _, key := range tableCache.Hot() {
+		slog.InfoContext(ctx, "refresh hot table", "key", key)
+		revalidateTable(key)
+	}
+	return nil
+}
+
+// tableWithQuery is the table of /table_v2, with the canonical query it was resolved to.
+type tableWithQuery struct {
+	Query *pkg.TableParams `json:"query"`
+	*pkg.Table
+}
+
+// writeTable writes the table of entry, along with query if not nil.
+func writeTable(w http.ResponseWriter, r *http.Request, entry *pkg.CachedTable, freshness pkg.Freshness, query *pkg.TableParams) {
+	w.Header().Set("Age", strconv.Itoa(int(entry.Age().Seconds())))
+	w.Header().Set("X-Cache", string(freshness))
+	if freshness == pkg.CacheStale || freshness == pkg.CacheExpired {
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+	}
+	maxAge := time.Duration(0)
+	if freshness == pkg.CacheFresh || freshness == pkg.CacheMiss {
+		maxAge = maxAgeUntil(entry.FetchedAt, c.CacheTTL)
+	}
+	if query != nil {
+		writeCacheable(w, r, tableWithQuery{Query: query, Table: entry.Table}, entry.FetchedAt, maxAge)
+		return
+	}
+	writeCacheable(w, r, entry.Table, entry.FetchedAt, maxAge)
+}
+
+// tableOf returns the table of params from the cache, revalidating it if stale, or fetches it on miss.
+// The last known table is returned as is if finviz is unavailable.
+func tableOf(ctx context.Context, params *pkg.TableParams) (*pkg.CachedTable, pkg.Freshness, error) {
+	key := params.CacheKey(c.EliteLogin)
+	slog.InfoContext(ctx, "to fetch page", "key", key)
+	// check cache
+	cached, freshness := tableCache.Get(ctx, key)
+	switch freshness {
+	case pkg.CacheFresh:
+		return cached, freshness, nil
+	case pkg.CacheStale:
+		revalidateTable(key)
+		return cached, freshness, nil
+	}
+	// fetch page and parse table
+	entry, err, shared := fetchTable(ctx, key)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, freshness, err
+		}
+		slog.ErrorContext(ctx, "fetch page and parse table", "err", err, "shared", shared)
+		// serve the last known table as stale if finviz is unavailable
+		if cached != nil {
+			slog.WarnContext(ctx, "serve stale table", "key", key)
+			return cached, freshness, nil
+		}
+		return nil, freshness, err
+	}
+	return entry, pkg.CacheMiss, nil
+}
+
+// maxChunkFetches bounds the chunks of a request fetched at once, so a long ticker list can't flood finviz.
+const maxChunkFetches = 2
+
+// freshnessOrder ranks freshness from the best, a merged table is as fresh as its stalest chunk.
+var freshnessOrder = map[pkg.Freshness]int{pkg.CacheFresh: 0, pkg.CacheMiss: 1, pkg.CacheStale: 2, pkg.CacheExpired: 3}
+
+// serveTable serves the table of params, echoing params as the query if echoQuery.
+// Tickers over pkg.TickersPerRequest are fetched in chunks, maxChunkFetches at once, each charged to the rate limit,
+// and rows are merged in the order of the tickers.
+func serveTable(w http.ResponseWriter, r *http.Request, params *pkg.TableParams, echoQuery bool) {
+	var query *pkg.TableParams
+	if echoQuery {
+		query = params
+	}
+	chunks := params.Chunks()
+	charge(w, r, len(chunks)-1) // the first chunk is charged by rateLimit
+	entries := make([]*pkg.CachedTable, len(chunks))
+	freshnesses := make([]pkg.Freshness, len(chunks))
+	errs := make([]error, len(chunks))
+	slots := make(chan struct{}, maxChunkFetches)
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, chunk *pkg.TableParams) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			entries[i], freshnesses[i], errs[i] = tableOf(r.Context(), chunk)
+		}(i, chunk)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		if r.Context().Err() != nil {
+			slog.WarnContext(r.Context(), "client gone while fetching table", "err", err)
+			return
+		}
+		upstreamProblem(w, r, err)
+		return
+	}
+	if len(params.Tickers) == 0 {
+		writeTable(w, r, entries[0], freshnesses[0], query)
+		return
+	}
+	merged := &pkg.CachedTable{FetchedAt: entries[0].FetchedAt}
+	freshness := pkg.CacheFresh
+	tables := make([]*pkg.Table, len(entries))
+	for i, entry := range entries {
+		tables[i] = entry.Table
+		if entry.FetchedAt.Before(merged.FetchedAt) {
+			merged.FetchedAt = entry.FetchedAt
+		}
+		if freshnessOrder[freshnesses[i]] > freshnessOrder[freshness] {
+			freshness = freshnesses[i]
+		}
+	}
+	merged.Table = pkg.MergeTables(params.Tickers, tables)
+	writeTable(w, r, merged, freshness, query)
+}
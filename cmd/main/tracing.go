@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+var tracer = otel.Tracer("github.com/ppaanngggg/finviz-proxy/cmd/main")
+
+// setupTracing exports spans through exporter, and propagates the W3C trace context of incoming requests.
+// Shutdown the returned provider to flush pending spans and stop exporting.
+func setupTracing(exporter sdktrace.SpanExporter, sampleRatio float64) *sdktrace.TracerProvider {
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("finviz-proxy"))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	return provider
+}
+
+// newTraceExporter exports spans by OTLP over http to c.OtlpEndpoint, other options are read from the
+// standard OTEL_EXPORTER_OTLP_* environments.
+func newTraceExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	return otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(c.OtlpEndpoint))
+}
+
+// nameSpan names the server span by the matched route pattern, once the request is routed.
+func nameSpan(r *http.Request) {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		trace.SpanFromContext(r.Context()).SetName(r.Method + " " + rctx.RoutePattern())
+	}
+}
+
+type parentSpanKey struct{}
+
+// traceWait wraps a middleware which may hold requests, like throttle, with a span ending once it lets the request through,
+// so the time waiting for it shows up in traces.
+func traceWait(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			trace.SpanFromContext(ctx).End()
+			// continue under the span of the request, instead of the ended one
+			if parent, ok := ctx.Value(parentSpanKey{}).(trace.Span); ok {
+				ctx = trace.ContextWithSpan(ctx, parent)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), parentSpanKey{}, trace.SpanFromContext(r.Context()))
+			ctx, span := tracer.Start(ctx, name)
+			defer span.End() // in case the request is rejected while waiting
+			wrapped.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
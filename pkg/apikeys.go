@@ -0,0 +1,166 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidAPIKey  = errors.New("invalid api key")
+	ErrRateLimited    = errors.New("rate limit exceeded")
+	ErrQuotaExhausted = errors.New("daily quota exhausted")
+)
+
+type APIKey struct {
+	Name       string  `json:"name"` // who is calling, as shown in logs and metrics
+	Key        string  `json:"key"`
+	Rate       float64 `json:"rate"`       // requests per second, 0 for unlimited
+	Burst      int     `json:"burst"`      // defaults to rate
+	DailyQuota int64   `json:"dailyQuota"` // requests per UTC day, 0 for unlimited
+}
+
+type apiKeysFile struct {
+	Keys []APIKey `json:"keys"`
+}
+
+type keyLimits struct {
+	APIKey
+	bucket *TokenBucket
+	quota  *DailyQuota
+}
+
+// KeyDecision is the result of a request with an API key, with the state of its limits for rate limit headers.
+type KeyDecision struct {
+	Name           string
+	RateLimit      int // 0 if the key has no rate limit
+	RateRemaining  int
+	RateReset      time.Duration
+	QuotaLimit     int64 // 0 if the key has no daily quota
+	QuotaRemaining int64
+	QuotaReset     time.Time
+	RetryAfter     time.Duration // set if rejected by the rate limit or quota
+}
+
+// KeyStore holds API keys loaded from a JSON file as {"keys": [...]}, reloaded when the file changes.
+// Limits of a key are kept across reloads as long as its rate, burst and quota don't change.
+type KeyStore struct {
+	path string
+
+	mu      sync.RWMutex
+	modTime time.Time
+	keys    map[[sha256.Size]byte]*keyLimits
+}
+
+func NewKeyStore(path string) (*KeyStore, error) {
+	s := &KeyStore{path: path}
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload loads the keys file if it changed since the last load, and reports whether it did.
+func (s *KeyStore) Reload() (bool, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	unchanged := info.ModTime().Equal(s.modTime)
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return false, err
+	}
+	file := &apiKeysFile{}
+	if err = json.Unmarshal(content, file); err != nil {
+		return false, errors.Wrap(err, "decode api keys")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make(map[[sha256.Size]byte]*keyLimits, len(file.Keys))
+	for _, key := range file.Keys {
+		if key.Key == "" || key.Name == "" {
+			return false, fmt.Errorf("api key without key or name: %q", key.Name)
+		}
+		hash := sha256.Sum256([]byte(key.Key))
+		if _, ok := keys[hash]; ok {
+			return false, fmt.Errorf("duplicated api key of %s", key.Name)
+		}
+		if current, ok := s.keys[hash]; ok && current.APIKey == key {
+			keys[hash] = current
+			continue
+		}
+		limits := &keyLimits{APIKey: key}
+		if key.Rate > 0 {
+			limits.bucket = NewTokenBucket(key.Rate, key.Burst)
+		}
+		if key.DailyQuota > 0 {
+			limits.quota = NewDailyQuota(key.DailyQuota)
+		}
+		keys[hash] = limits
+	}
+	s.keys = keys
+	s.modTime = info.ModTime()
+	return true, nil
+}
+
+// Watch reloads the keys file every interval until ctx is done, a bad file is logged and the current keys are kept.
+func (s *KeyStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if reloaded, err := s.Reload(); err != nil {
+				slog.ErrorContext(ctx, "failed to reload api keys", "path", s.path, "err", err)
+			} else if reloaded {
+				slog.InfoContext(ctx, "api keys reloaded", "path", s.path)
+			}
+		}
+	}
+}
+
+// Allow checks the key and takes a request from its rate limit and daily quota.
+// It returns ErrInvalidAPIKey, ErrRateLimited or ErrQuotaExhausted if the request is rejected.
+func (s *KeyStore) Allow(key string) (KeyDecision, error) {
+	s.mu.RLock()
+	limits, ok := s.keys[sha256.Sum256([]byte(key))]
+	s.mu.RUnlock()
+	if !ok || key == "" {
+		return KeyDecision{}, ErrInvalidAPIKey
+	}
+	decision := KeyDecision{Name: limits.Name}
+	if limits.bucket != nil {
+		var allowed bool
+		allowed, decision.RateRemaining, decision.RateReset = limits.bucket.Take()
+		decision.RateLimit = limits.bucket.Burst()
+		if !allowed {
+			decision.RetryAfter = decision.RateReset
+			return decision, ErrRateLimited
+		}
+	}
+	if limits.quota != nil {
+		var allowed bool
+		allowed, decision.QuotaRemaining, decision.QuotaReset = limits.quota.Take()
+		decision.QuotaLimit = limits.quota.Limit()
+		if !allowed {
+			decision.RetryAfter = time.Until(decision.QuotaReset)
+			return decision, ErrQuotaExhausted
+		}
+	}
+	return decision, nil
+}
@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_KeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"keys": [
+		{"name": "team-a", "key": "key-a", "rate": 1, "burst": 1},
+		{"name": "team-b", "key": "key-b", "dailyQuota": 1}
+	]}`), 0644))
+	s, err := NewKeyStore(path)
+	assert.NoError(t, err)
+
+	_, err = s.Allow("unknown")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	_, err = s.Allow("")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	decision, err := s.Allow("key-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "team-a", decision.Name)
+	assert.Equal(t, 1, decision.RateLimit)
+	decision, err = s.Allow("key-a")
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Greater(t, decision.RetryAfter, time.Duration(0))
+
+	decision, err = s.Allow("key-b")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), decision.QuotaRemaining)
+	_, err = s.Allow("key-b")
+	assert.ErrorIs(t, err, ErrQuotaExhausted)
+
+	// unchanged file is not reloaded
+	reloaded, err := s.Reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+
+	// unchanged keys keep their limits, removed keys are revoked
+	assert.NoError(t, os.WriteFile(path, []byte(`{"keys": [
+		{"name": "team-b", "key": "key-b", "dailyQuota": 1},
+		{"name": "team-c", "key": "key-c"}
+	]}`), 0644))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	reloaded, err = s.Reload()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+	_, err = s.Allow("key-a")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	_, err = s.Allow("key-b")
+	assert.ErrorIs(t, err, ErrQuotaExhausted)
+	_, err = s.Allow("key-c")
+	assert.NoError(t, err)
+
+	// a bad file keeps the current keys
+	assert.NoError(t, os.WriteFile(path, []byte(`{"keys": [{"name": "no key"}]}`), 0644))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second)))
+	_, err = s.Reload()
+	assert.Error(t, err)
+	_, err = s.Allow("key-c")
+	assert.NoError(t, err)
+}
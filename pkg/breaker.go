@@ -0,0 +1,207 @@
+package pkg
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// ErrCircuitOpen is returned without touching finviz when the breaker of an upstream endpoint is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+func IsCircuitOpen(err error) bool {
+	return errors.Is(err, ErrCircuitOpen)
+}
+
+type CircuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+	}
+}
+
+// Allow reports whether a request may be sent upstream. Once the cooldown of an open breaker is over,
+// a single probe is let through in half-open state, and everything else keeps failing fast until it returns.
+func (b *CircuitBreaker) Allow(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.setState(ctx, BreakerHalfOpen)
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) Success(ctx context.Context) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+	if b.state != BreakerClosed {
+		b.setState(ctx, BreakerClosed)
+	}
+}
+
+func (b *CircuitBreaker) Failure(ctx context.Context) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		b.openedAt = time.Now()
+		b.setState(ctx, BreakerOpen)
+	}
+}
+
+// Cancel releases a probe whose request was abandoned by the caller, without counting it either way.
+func (b *CircuitBreaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *CircuitBreaker) setState(ctx context.Context, state BreakerState) {
+	slog.WarnContext(
+		ctx, "circuit breaker state changed",
+		"endpoint", b.name, "from", b.state, "to", state, "failures", b.failures,
+	)
+	b.state = state
+}
+
+type BreakerStatus struct {
+	Endpoint            string       `json:"endpoint"`
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutiveFailures"`
+	OpenedAt            *time.Time   `json:"openedAt,omitempty"`
+}
+
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := BreakerStatus{
+		Endpoint:            b.name,
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+const (
+	EndpointScreener = "screener"
+	EndpointNews     = "news"
+	EndpointFutures  = "futures"
+)
+
+var breakers = map[string]*CircuitBreaker{
+	EndpointScreener: NewCircuitBreaker(EndpointScreener, 5, 30*time.Second),
+	EndpointNews:     NewCircuitBreaker(EndpointNews, 5, 30*time.Second),
+	EndpointFutures:  NewCircuitBreaker(EndpointFutures, 5, 30*time.Second),
+}
+
+// ConfigureBreakers resets every upstream breaker with the given threshold of consecutive failures and cooldown.
+func ConfigureBreakers(threshold int, cooldown time.Duration) {
+	for name := range breakers {
+		breakers[name] = NewCircuitBreaker(name, threshold, cooldown)
+	}
+}
+
+func BreakerStatuses() []BreakerStatus {
+	ret := make([]BreakerStatus, 0, len(breakers))
+	for _, name := range []string{EndpointScreener, EndpointNews, EndpointFutures} {
+		ret = append(ret, breakers[name].Status())
+	}
+	return ret
+}
+
+func endpointOf(path string) string {
+	switch {
+	case strings.HasSuffix(path, "/screener.ashx"):
+		return EndpointScreener
+	case strings.HasSuffix(path, "/news.ashx"):
+		return EndpointNews
+	case strings.HasSuffix(path, "/futures_all.ashx"):
+		return EndpointFutures
+	}
+	return ""
+}
+
+// breakerTransport guards finviz requests with the breaker of their endpoint, and records their latency.
+// Transport errors, 5xx and 429/403 (finviz blocking us) count as failures.
+type breakerTransport struct {
+	next http.RoundTripper
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	breaker, ok := breakers[endpointOf(req.URL.Path)]
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+	if err := breaker.Allow(req.Context()); err != nil {
+		slog.WarnContext(req.Context(), "skip upstream request", "endpoint", breaker.name, "err", err)
+		upstreamRequests.WithLabelValues(breaker.name, codeCircuitOpen).Inc()
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		latencies[breaker.name].record(time.Since(start))
+		upstreamDuration.WithLabelValues(breaker.name).Observe(time.Since(start).Seconds())
+		upstreamRequests.WithLabelValues(breaker.name, strconv.Itoa(resp.StatusCode)).Inc()
+	} else {
+		upstreamRequests.WithLabelValues(breaker.name, codeError).Inc()
+	}
+	switch {
+	case err != nil && errors.Is(req.Context().Err(), context.Canceled):
+		breaker.Cancel()
+	case err != nil:
+		breaker.Failure(req.Context())
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusForbidden:
+		breaker.Failure(req.Context())
+	default:
+		breaker.Success(req.Context())
+	}
+	return resp, err
+}
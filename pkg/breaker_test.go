@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_CircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	b := NewCircuitBreaker("test", 2, 20*time.Millisecond)
+	assert.NoError(t, b.Allow(ctx))
+	b.Failure(ctx)
+	assert.NoError(t, b.Allow(ctx))
+	b.Failure(ctx)
+	// tripped after 2 consecutive failures
+	assert.Equal(t, BreakerOpen, b.Status().State)
+	assert.True(t, IsCircuitOpen(b.Allow(ctx)))
+	// half-open lets a single probe through after cooldown
+	time.Sleep(30 * time.Millisecond)
+	assert.NoError(t, b.Allow(ctx))
+	assert.Equal(t, BreakerHalfOpen, b.Status().State)
+	assert.True(t, IsCircuitOpen(b.Allow(ctx)))
+	// failed probe opens again
+	b.Failure(ctx)
+	assert.Equal(t, BreakerOpen, b.Status().State)
+	time.Sleep(30 * time.Millisecond)
+	assert.NoError(t, b.Allow(ctx))
+	b.Success(ctx)
+	assert.Equal(t, BreakerClosed, b.Status().State)
+	assert.Equal(t, 0, b.Status().ConsecutiveFailures)
+}
+
+func Test_breakerTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	ConfigureBreakers(2, time.Minute)
+	defer ConfigureBreakers(5, 30*time.Second)
+
+	forbidden := testutil.ToFloat64(upstreamRequests.WithLabelValues(EndpointScreener, "403"))
+	skipped := testutil.ToFloat64(upstreamRequests.WithLabelValues(EndpointScreener, codeCircuitOpen))
+	client := &http.Client{Transport: &breakerTransport{next: http.DefaultTransport}}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL + "/screener.ashx")
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	_, err := client.Get(server.URL + "/screener.ashx")
+	assert.True(t, IsCircuitOpen(err))
+	assert.Equal(t, forbidden+2, testutil.ToFloat64(upstreamRequests.WithLabelValues(EndpointScreener, "403")))
+	assert.Equal(t, skipped+1, testutil.ToFloat64(upstreamRequests.WithLabelValues(EndpointScreener, codeCircuitOpen)))
+	// other endpoints are not affected
+	resp, err := client.Get(server.URL + "/news.ashx")
+	assert.NoError(t, err)
+	resp.Body.Close()
+}
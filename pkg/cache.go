@@ -0,0 +1,208 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Freshness string
+
+const (
+	CacheFresh   Freshness = "fresh"   // younger than FreshTTL, served as is
+	CacheStale   Freshness = "stale"   // within StaleTTL after FreshTTL, served while being refreshed
+	CacheExpired Freshness = "expired" // only kept to be served when finviz is unavailable
+	CacheMiss    Freshness = "miss"
+)
+
+type CachePolicy struct {
+	FreshTTL  time.Duration
+	StaleTTL  time.Duration
+	RetainTTL time.Duration
+	// count requests per entry to find hot screens, 0 disables it
+	HotRequests int64
+}
+
+type CacheStats struct {
+	StoreStats
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+type CachedTable struct {
+	Table     *Table    `json:"table"`
+	FetchedAt time.Time `json:"fetchedAt"`
+}
+
+func (e *CachedTable) Age() time.Duration {
+	return time.Since(e.FetchedAt)
+}
+
+type hotEntry struct {
+	fetchedAt time.Time
+	requests  int64
+}
+
+// TableCache keeps tables in a CacheStore with separate fresh and stale TTLs,
+// and counts requests per entry of this instance to find hot screens.
+type TableCache struct {
+	policy CachePolicy
+	store  CacheStore
+	hits   atomic.Uint64
+	misses atomic.Uint64
+
+	mu  sync.Mutex
+	hot map[string]*hotEntry
+}
+
+func NewTableCache(policy CachePolicy, store CacheStore) *TableCache {
+	if policy.RetainTTL < policy.FreshTTL+policy.StaleTTL {
+		policy.RetainTTL = policy.FreshTTL + policy.StaleTTL
+	}
+	return &TableCache{
+		policy: policy,
+		store:  store,
+		hot:    make(map[string]*hotEntry),
+	}
+}
+
+func (c *TableCache) Policy() CachePolicy {
+	return c.policy
+}
+
+// Get returns the cached table of key and its freshness, errors of the store are logged and taken as miss.
+func (c *TableCache) Get(ctx context.Context, key string) (*CachedTable, Freshness) {
+	ctx, span := tracer.Start(ctx, "TableCache.Get", trace.WithAttributes(attribute.String("cache.key", key)))
+	defer span.End()
+	entry, err := c.load(ctx, key)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get table from cache", "key", key, "err", err)
+		span.RecordError(err)
+	}
+	if entry == nil {
+		c.misses.Add(1)
+		cacheRequests.WithLabelValues(string(CacheMiss)).Inc()
+		span.SetAttributes(attribute.String("cache.freshness", string(CacheMiss)))
+		return nil, CacheMiss
+	}
+	freshness := c.freshness(entry)
+	cacheRequests.WithLabelValues(string(freshness)).Inc()
+	span.SetAttributes(attribute.String("cache.freshness", string(freshness)))
+	if freshness == CacheExpired {
+		c.misses.Add(1)
+	} else {
+		c.hits.Add(1)
+		c.countRequest(key, entry)
+	}
+	return entry, freshness
+}
+
+func (c *TableCache) load(ctx context.Context, key string) (*CachedTable, error) {
+	value, found, err := c.store.Get(ctx, "table:"+key)
+	if err != nil || !found {
+		return nil, err
+	}
+	entry := &CachedTable{}
+	if err = json.Unmarshal(value, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (c *TableCache) Set(ctx context.Context, key string, table *Table) *CachedTable {
+	ctx, span := tracer.Start(ctx, "TableCache.Set", trace.WithAttributes(attribute.String("cache.key", key)))
+	entry := &CachedTable{Table: table, FetchedAt: time.Now()}
+	value, err := json.Marshal(entry)
+	if err == nil {
+		err = c.store.Set(ctx, "table:"+key, value, c.policy.RetainTTL)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to set table to cache", "key", key, "err", err)
+	}
+	endSpan(span, err)
+	// requests are counted again for the new entry, so a refreshed key isn't hot until requested again
+	c.mu.Lock()
+	delete(c.hot, key)
+	c.mu.Unlock()
+	return entry
+}
+
+func (c *TableCache) Purge(ctx context.Context) error {
+	c.mu.Lock()
+	c.hot = make(map[string]*hotEntry)
+	c.mu.Unlock()
+	return c.store.Purge(ctx)
+}
+
+func (c *TableCache) Stats(ctx context.Context) (CacheStats, error) {
+	storeStats, err := c.store.Stats(ctx)
+	return CacheStats{
+		StoreStats: storeStats,
+		Hits:       c.hits.Load(),
+		Misses:     c.misses.Load(),
+	}, err
+}
+
+// Counters returns the hit and miss counters without scanning the store, store stats are only included
+// for the memory store, which keeps them as counters too. See Stats for the full stats.
+func (c *TableCache) Counters(ctx context.Context) CacheStats {
+	stats := CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+	switch store := c.store.(type) {
+	case *MemoryStore:
+		stats.StoreStats, _ = store.Stats(ctx)
+	case *BoltStore:
+		stats.Backend = "bolt"
+	case *RedisStore:
+		stats.Backend = "redis"
+	}
+	return stats
+}
+
+func (c *TableCache) freshness(entry *CachedTable) Freshness {
+	age := entry.Age()
+	switch {
+	case age < c.policy.FreshTTL:
+		return CacheFresh
+	case age < c.policy.FreshTTL+c.policy.StaleTTL:
+		return CacheStale
+	}
+	return CacheExpired
+}
+
+func (c *TableCache) countRequest(key string, entry *CachedTable) {
+	if c.policy.HotRequests <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hot, ok := c.hot[key]
+	if !ok || !hot.fetchedAt.Equal(entry.FetchedAt) {
+		hot = &hotEntry{fetchedAt: entry.FetchedAt}
+		c.hot[key] = hot
+	}
+	hot.requests++
+}
+
+// Hot returns keys requested at least HotRequests times since they were fetched,
+// and which are older than half of FreshTTL, so they can be refreshed before going stale.
+func (c *TableCache) Hot() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var keys []string
+	for key, hot := range c.hot {
+		age := time.Since(hot.fetchedAt)
+		if age >= c.policy.FreshTTL+c.policy.StaleTTL {
+			delete(c.hot, key)
+			continue
+		}
+		if hot.requests >= c.policy.HotRequests && age >= c.policy.FreshTTL/2 {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
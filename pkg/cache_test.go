@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_TableCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewTableCache(CachePolicy{
+		FreshTTL:    40 * time.Millisecond,
+		StaleTTL:    40 * time.Millisecond,
+		RetainTTL:   time.Second,
+		HotRequests: 2,
+	}, NewMemoryStore(CacheLimits{}))
+	_, freshness := c.Get(ctx, "o=ticker")
+	assert.Equal(t, CacheMiss, freshness)
+
+	table := &Table{Headers: []string{"No."}, Rows: [][]string{{"1"}}}
+	c.Set(ctx, "o=ticker", table)
+	entry, freshness := c.Get(ctx, "o=ticker")
+	assert.Equal(t, CacheFresh, freshness)
+	assert.Equal(t, table, entry.Table)
+
+	time.Sleep(50 * time.Millisecond)
+	_, freshness = c.Get(ctx, "o=ticker")
+	assert.Equal(t, CacheStale, freshness)
+	// requested 2 times since fetched
+	assert.Equal(t, []string{"o=ticker"}, c.Hot())
+
+	time.Sleep(40 * time.Millisecond)
+	entry, freshness = c.Get(ctx, "o=ticker")
+	assert.Equal(t, CacheExpired, freshness)
+	assert.Equal(t, table, entry.Table)
+	assert.Empty(t, c.Hot())
+
+	stats, err := c.Stats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Misses)
+	assert.Equal(t, 1, stats.Entries)
+	assert.Equal(t, stats, c.Counters(ctx))
+
+	// setting a hot key resets its requests, so it isn't refreshed again until requested again
+	c.Set(ctx, "o=price", table)
+	time.Sleep(25 * time.Millisecond)
+	c.Get(ctx, "o=price")
+	c.Get(ctx, "o=price")
+	assert.Equal(t, []string{"o=price"}, c.Hot())
+	c.Set(ctx, "o=price", table)
+	assert.Empty(t, c.Hot())
+	time.Sleep(25 * time.Millisecond)
+	assert.Empty(t, c.Hot())
+
+	// bolt stats scan every entry, so counters leave them out
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "cache.db"))
+	assert.NoError(t, err)
+	defer store.Close()
+	c = NewTableCache(CachePolicy{FreshTTL: time.Minute}, store)
+	c.Set(ctx, "o=ticker", table)
+	c.Get(ctx, "o=ticker")
+	assert.Equal(t, CacheStats{StoreStats: StoreStats{Backend: "bolt"}, Hits: 1}, c.Counters(ctx))
+}
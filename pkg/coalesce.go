@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"context"
+	"sync"
+)
+
+type coalescedCall[T any] struct {
+	done    chan struct{}
+	val     T
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Coalescer runs only one fn per key at a time, every concurrent caller of the same key shares its result.
+// The shared call is detached from the leader's context, so it keeps running while any caller still waits,
+// and is canceled once all of them are gone.
+type Coalescer[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall[T]
+}
+
+func NewCoalescer[T any]() *Coalescer[T] {
+	return &Coalescer[T]{calls: make(map[string]*coalescedCall[T])}
+}
+
+// Do returns the result of fn for key, shared is true if the result came from a call started by another caller.
+func (g *Coalescer[T]) Do(
+	ctx context.Context, key string, fn func(ctx context.Context) (T, error),
+) (val T, err error, shared bool) {
+	g.mu.Lock()
+	call, shared := g.calls[key]
+	if !shared {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &coalescedCall[T]{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		go func() {
+			call.val, call.err = fn(callCtx)
+			g.mu.Lock()
+			g.forget(key, call)
+			g.mu.Unlock()
+			cancel()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.val, call.err, shared
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// nobody is interested anymore, later callers start a new call
+			g.forget(key, call)
+			call.cancel()
+		}
+		g.mu.Unlock()
+		return val, ctx.Err(), shared
+	}
+}
+
+func (g *Coalescer[T]) forget(key string, call *coalescedCall[T]) {
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
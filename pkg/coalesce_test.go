@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_Coalescer(t *testing.T) {
+	g := NewCoalescer[int]()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (int, error) {
+		calls.Add(1)
+		<-release
+		return 42, errors.New("shared error")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err, _ := g.Do(context.Background(), "key", fn)
+			assert.Equal(t, 42, val)
+			assert.EqualError(t, err, "shared error")
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func Test_Coalescer_leaderGone(t *testing.T) {
+	g := NewCoalescer[int]()
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (int, error) {
+		close(started)
+		select {
+		case <-release:
+			return 1, nil
+		case <-ctx.Done():
+			close(canceled)
+			return 0, ctx.Err()
+		}
+	}
+
+	leaderCtx, leaderCancel := context.WithCancel(context.Background())
+	leaderDone := make(chan error)
+	go func() {
+		_, err, _ := g.Do(leaderCtx, "key", fn)
+		leaderDone <- err
+	}()
+	<-started
+	followerDone := make(chan int)
+	go func() {
+		val, err, shared := g.Do(context.Background(), "key", fn)
+		assert.NoError(t, err)
+		assert.True(t, shared)
+		followerDone <- val
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// the leader leaves, but the follower still gets the result
+	leaderCancel()
+	assert.ErrorIs(t, <-leaderDone, context.Canceled)
+	close(release)
+	assert.Equal(t, 1, <-followerDone)
+
+	// the call is canceled once every caller is gone
+	g = NewCoalescer[int]()
+	started = make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	_, err, _ := g.Do(ctx, "key", func(ctx context.Context) (int, error) {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return 0, ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("call is not canceled")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"log/slog"
 	"net/http"
 )
@@ -18,7 +20,9 @@ type FutureQuota struct {
 	Low       float64 `json:"low"`
 }
 
-func FetchAllFutures(ctx context.Context, isElite bool) (map[string]FutureQuota, error) {
+func FetchAllFutures(ctx context.Context, isElite bool) (ret map[string]FutureQuota, err error) {
+	ctx, span := tracer.Start(ctx, "FetchAllFutures", trace.WithAttributes(attribute.Bool("finviz.elite", isElite)))
+	defer func() { endSpan(span, err) }()
 	url := "https://finviz.com/api/futures_all.ashx?timeframe=NO"
 	if isElite {
 		url = "https://elite.finviz.com/api/futures_all.ashx?timeframe=NO"
@@ -26,27 +30,28 @@ func FetchAllFutures(ctx context.Context, isElite bool) (map[string]FutureQuota,
 	// request
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		slog.Error("FetchAllFutures http new request", "err", err)
+		slog.ErrorContext(ctx, "FetchAllFutures http new request", "err", err)
 		return nil, err
 	}
 	req.Header.Set("User-Agent", "curl/7.88.1")
 	client := newClient()
 	resp, err := client.Do(req)
 	if err != nil {
-		slog.Error("FetchAllFutures http do", "err", err)
+		slog.ErrorContext(ctx, "FetchAllFutures http do", "err", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		slog.Error("FetchAllFutures status code not ok", "status", resp.StatusCode)
+		slog.ErrorContext(ctx, "FetchAllFutures status code not ok", "status", resp.StatusCode)
 		return nil, errors.New("FetchAllFutures status code not ok")
 	}
 	// unmarshal to map
-	ret := make(map[string]FutureQuota)
+	ret = make(map[string]FutureQuota)
 	err = json.NewDecoder(resp.Body).Decode(&ret)
 	if err != nil {
-		slog.Error("FetchAllFutures json decode response", "err", err)
-		return nil, err
+		slog.ErrorContext(ctx, "FetchAllFutures json decode response", "err", err)
+		parseFailures.WithLabelValues("parseFutures").Inc()
+		return nil, &ParseError{Parser: "parseFutures", Err: err}
 	}
 	return ret, nil
 }
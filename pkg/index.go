@@ -0,0 +1,327 @@
+package pkg
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxSuggestions = 3
+
+// suffixes of numeric options, as u5 (under 5), o50 (over 50), d5 (down 5%) and 5to10 or -10to-5
+var numericOption = regexp.MustCompile(`^(?:([uod])(\d+(?:\.\d+)?)|(-?\d+(?:\.\d+)?)to(-?\d+(?:\.\d+)?))$`)
+
+// RangeDomain bounds the custom ranges of a numeric filter, infinite where it can't be known.
+type RangeDomain struct {
+	Min float64
+	Max float64
+}
+
+func (d RangeDomain) Contains(value float64) bool {
+	return value >= d.Min && value <= d.Max
+}
+
+// filterPrefix returns the prefix of the option values of filter, which is its id without fs_,
+// as fs_fa_pe and fa_pe_u20, or "" if an option doesn't have it.
+func filterPrefix(filter Filter) string {
+	prefix := strings.TrimPrefix(filter.Id, "fs_") + "_"
+	for _, option := range filter.Options {
+		if !strings.HasPrefix(option.Value, prefix) {
+			return ""
+		}
+	}
+	return prefix
+}
+
+// rangeDomainOf returns the domain of custom ranges of filter, and false if none of its options is numeric.
+// Options are presets rather than the domain, so only a lower bound of 0 is derived, as for prices and ratios,
+// unless some option is negative, as d5 (down 5%), u0, -10to-5 or neg, and there is no upper bound.
+func rangeDomainOf(filter Filter, prefix string) (RangeDomain, bool) {
+	numeric, negative := false, false
+	for _, option := range filter.Options {
+		suffix := strings.TrimPrefix(option.Value, prefix)
+		match := numericOption.FindStringSubmatch(suffix)
+		if match == nil {
+			name := strings.ToLower(option.Name)
+			negative = negative || strings.HasPrefix(suffix, "neg") || strings.Contains(name, "negative") ||
+				strings.Contains(name, "<0")
+			continue
+		}
+		numeric = true
+		switch match[1] {
+		case "":
+			from, _ := strconv.ParseFloat(match[3], 64)
+			negative = negative || from < 0
+		case "d":
+			negative = true
+		case "u":
+			// under 0 is negative, and up along with d is non-negative too
+			under, _ := strconv.ParseFloat(match[2], 64)
+			negative = negative || under <= 0
+		}
+	}
+	domain := RangeDomain{Min: math.Inf(-1), Max: math.Inf(1)}
+	if !negative {
+		domain.Min = 0
+	}
+	return domain, numeric
+}
+
+// ParamsIndex looks up the values of Params in O(1), resolves human names to values,
+// and suggests the nearest values for typos.
+type ParamsIndex struct {
+	params  *Params
+	sorters map[string]bool
+	signals map[string]bool
+	options map[string]bool            // values of all filter options
+	filters map[string]map[string]bool // option values by filter id
+	// by filter id, prefixes of option values and range domains of numeric filters
+	prefixes map[string]string
+	ranges   map[string]RangeDomain
+
+	// lower case names to values, the first one wins if names are not unique
+	sorterNames map[string]string
+	signalNames map[string]string
+	filterNames map[string]string
+	optionNames map[string]map[string]string // by filter id
+}
+
+func addName(names map[string]string, name string, value string) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if _, ok := names[name]; !ok && name != "" {
+		names[name] = value
+	}
+}
+
+func newParamsIndex(params *Params) *ParamsIndex {
+	index := &ParamsIndex{
+		params:  params,
+		sorters: make(map[string]bool, len(params.Sorters)),
+		signals: make(map[string]bool, len(params.Signals)),
+		options: make(map[string]bool),
+		filters: make(map[string]map[string]bool, len(params.Filters)),
+
+		prefixes: make(map[string]string, len(params.Filters)),
+		ranges:   make(map[string]RangeDomain),
+
+		sorterNames: make(map[string]string, len(params.Sorters)),
+		signalNames: make(map[string]string, len(params.Signals)),
+		filterNames: make(map[string]string, len(params.Filters)),
+		optionNames: make(map[string]map[string]string, len(params.Filters)),
+	}
+	for _, sorter := range params.Sorters {
+		index.sorters[sorter.Value] = true
+		addName(index.sorterNames, sorter.Name, sorter.Value)
+	}
+	for _, signal := range params.Signals {
+		index.signals[signal.Value] = true
+		addName(index.signalNames, signal.Name, signal.Value)
+	}
+	for _, filter := range params.Filters {
+		options := make(map[string]bool, len(filter.Options))
+		names := make(map[string]string, len(filter.Options))
+		for _, option := range filter.Options {
+			options[option.Value] = true
+			index.options[option.Value] = true
+			addName(names, option.Name, option.Value)
+		}
+		index.filters[filter.Id] = options
+		index.optionNames[filter.Id] = names
+		addName(index.filterNames, filter.Name, filter.Id)
+		if prefix := filterPrefix(filter); prefix != "" {
+			index.prefixes[filter.Id] = prefix
+			if domain, ok := rangeDomainOf(filter, prefix); ok {
+				index.ranges[filter.Id] = domain
+			}
+		}
+	}
+	return index
+}
+
+// Index returns the index of p, built on first use, p should not be modified after that.
+func (p *Params) Index() *ParamsIndex {
+	p.indexOnce.Do(func() {
+		p.index = newParamsIndex(p)
+	})
+	return p.index
+}
+
+func (i *ParamsIndex) HasSorter(order string) bool {
+	return i.sorters[order]
+}
+
+func (i *ParamsIndex) HasSignal(signal string) bool {
+	return i.signals[signal]
+}
+
+func (i *ParamsIndex) HasFilter(id string) bool {
+	_, ok := i.filters[id]
+	return ok
+}
+
+// HasOption reports whether value is an option of the filter id, or of any filter if id is empty.
+func (i *ParamsIndex) HasOption(id string, value string) bool {
+	if id == "" {
+		return i.options[value]
+	}
+	return i.filters[id][value]
+}
+
+// ResolveSorter returns the sorter of value order, or of name order case-insensitively.
+func (i *ParamsIndex) ResolveSorter(order string) (string, bool) {
+	return resolve(i.sorters, i.sorterNames, order)
+}
+
+// ResolveSignal returns the signal of value signal, or of name signal case-insensitively.
+func (i *ParamsIndex) ResolveSignal(signal string) (string, bool) {
+	return resolve(i.signals, i.signalNames, signal)
+}
+
+// ResolveFilter returns the filter id of id, or of name id case-insensitively.
+func (i *ParamsIndex) ResolveFilter(id string) (string, bool) {
+	if i.HasFilter(id) {
+		return id, true
+	}
+	ret, ok := i.filterNames[strings.ToLower(strings.TrimSpace(id))]
+	return ret, ok
+}
+
+// ResolveOption returns the option value of value, or of label value case-insensitively, of the filter id.
+func (i *ParamsIndex) ResolveOption(id string, value string) (string, bool) {
+	return resolve(i.filters[id], i.optionNames[id], value)
+}
+
+// Prefix returns the prefix of the option values of the filter id, "" if unknown.
+func (i *ParamsIndex) Prefix(id string) string {
+	return i.prefixes[id]
+}
+
+// Range returns the domain of custom ranges of the filter id, and false if it doesn't take ranges.
+func (i *ParamsIndex) Range(id string) (RangeDomain, bool) {
+	domain, ok := i.ranges[id]
+	return domain, ok
+}
+
+func resolve(values map[string]bool, names map[string]string, value string) (string, bool) {
+	if values[value] {
+		return value, true
+	}
+	ret, ok := names[strings.ToLower(strings.TrimSpace(value))]
+	return ret, ok
+}
+
+func (i *ParamsIndex) SuggestSorters(order string) []string {
+	suggester := newSuggester(order)
+	for _, sorter := range i.params.Sorters {
+		suggester.add(sorter.Value, sorter.Name)
+	}
+	return suggester.suggestions()
+}
+
+func (i *ParamsIndex) SuggestSignals(signal string) []string {
+	suggester := newSuggester(signal)
+	for _, s := range i.params.Signals {
+		suggester.add(s.Value, s.Name)
+	}
+	return suggester.suggestions()
+}
+
+func (i *ParamsIndex) SuggestFilters(id string) []string {
+	suggester := newSuggester(id)
+	for _, filter := range i.params.Filters {
+		suggester.add(filter.Id, filter.Name)
+	}
+	return suggester.suggestions()
+}
+
+// SuggestOptions suggests options of the filter id, or of all filters if id is empty.
+func (i *ParamsIndex) SuggestOptions(id string, value string) []string {
+	suggester := newSuggester(value)
+	for _, filter := range i.params.Filters {
+		if id != "" && filter.Id != id {
+			continue
+		}
+		for _, option := range filter.Options {
+			suggester.add(option.Value, option.Name)
+		}
+	}
+	return suggester.suggestions()
+}
+
+type suggestion struct {
+	value    string
+	distance int
+}
+
+// suggester keeps the values nearest to target by edit distance of the value or its human name,
+// case-insensitive, and within a third of the target length.
+type suggester struct {
+	target  string
+	maxDist int
+	found   map[string]int
+}
+
+func newSuggester(target string) *suggester {
+	target = strings.ToLower(target)
+	return &suggester{
+		target:  target,
+		maxDist: max(1, utf8.RuneCountInString(target)/3),
+		found:   make(map[string]int),
+	}
+}
+
+func (s *suggester) add(value string, name string) {
+	distance := min(levenshtein(s.target, strings.ToLower(value)), levenshtein(s.target, strings.ToLower(name)))
+	if distance > s.maxDist {
+		return
+	}
+	if d, ok := s.found[value]; !ok || distance < d {
+		s.found[value] = distance
+	}
+}
+
+func (s *suggester) suggestions() []string {
+	suggestions := make([]suggestion, 0, len(s.found))
+	for value, distance := range s.found {
+		suggestions = append(suggestions, suggestion{value: value, distance: distance})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].distance != suggestions[j].distance {
+			return suggestions[i].distance < suggestions[j].distance
+		}
+		return suggestions[i].value < suggestions[j].value
+	})
+	var ret []string
+	for _, suggestion := range suggestions {
+		if len(ret) == maxSuggestions {
+			break
+		}
+		ret = append(ret, suggestion.value)
+	}
+	return ret
+}
+
+// levenshtein is the edit distance of a and b in runes.
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"sync"
+	"time"
+)
+
+// UpstreamLatency summarizes the time to response headers of an upstream endpoint.
+type UpstreamLatency struct {
+	Endpoint string `json:"endpoint"`
+	Requests int64  `json:"requests"`
+	Last     string `json:"last"`
+	Average  string `json:"average"` // exponentially weighted, recent requests weigh more
+	Max      string `json:"max"`
+}
+
+// latencyWeight is the weight of the latest request in the average.
+const latencyWeight = 0.2
+
+type latencyTracker struct {
+	mu       sync.Mutex
+	requests int64
+	last     time.Duration
+	average  time.Duration
+	max      time.Duration
+}
+
+func (l *latencyTracker) record(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.requests == 0 {
+		l.average = d
+	} else {
+		l.average = time.Duration(latencyWeight*float64(d) + (1-latencyWeight)*float64(l.average))
+	}
+	l.requests++
+	l.last = d
+	l.max = max(l.max, d)
+}
+
+var latencies = map[string]*latencyTracker{
+	EndpointScreener: {},
+	EndpointNews:     {},
+	EndpointFutures:  {},
+}
+
+// UpstreamLatencyOf returns the latency of a finviz endpoint, one of EndpointScreener, EndpointNews and EndpointFutures.
+func UpstreamLatencyOf(endpoint string) UpstreamLatency {
+	ret := UpstreamLatency{Endpoint: endpoint}
+	l, ok := latencies[endpoint]
+	if !ok {
+		return ret
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ret.Requests = l.requests
+	ret.Last = l.last.String()
+	ret.Average = l.average.String()
+	ret.Max = l.max.String()
+	return ret
+}
@@ -0,0 +1,21 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_latencyTracker(t *testing.T) {
+	l := &latencyTracker{}
+	l.record(100 * time.Millisecond)
+	assert.Equal(t, 100*time.Millisecond, l.average)
+	l.record(200 * time.Millisecond)
+	assert.Equal(t, int64(2), l.requests)
+	assert.Equal(t, 200*time.Millisecond, l.last)
+	assert.Equal(t, 120*time.Millisecond, l.average)
+	assert.Equal(t, 200*time.Millisecond, l.max)
+
+	assert.Equal(t, UpstreamLatency{Endpoint: "unknown"}, UpstreamLatencyOf("unknown"))
+	assert.Equal(t, EndpointNews, UpstreamLatencyOf(EndpointNews).Endpoint)
+}
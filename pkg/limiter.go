@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter keeps a token bucket per client, idle buckets are dropped once full again.
+type RateLimiter struct {
+	rate  float64
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*limiterEntry
+	lastSweep time.Time
+}
+
+type limiterEntry struct {
+	bucket   *TokenBucket
+	lastSeen time.Time
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:      rate,
+		burst:     NewTokenBucket(rate, burst).Burst(),
+		buckets:   make(map[string]*limiterEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of client, see TokenBucket.Take.
+func (l *RateLimiter) Allow(client string) (ok bool, remaining int, wait time.Duration) {
+	return l.bucketOf(client).Take()
+}
+
+// Charge takes n more tokens from the bucket of client, see TokenBucket.Charge.
+func (l *RateLimiter) Charge(client string, n int) (remaining int, wait time.Duration) {
+	return l.bucketOf(client).Charge(n)
+}
+
+func (l *RateLimiter) bucketOf(client string) *TokenBucket {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, found := l.buckets[client]
+	if !found {
+		entry = &limiterEntry{bucket: NewTokenBucket(l.rate, l.burst)}
+		l.buckets[client] = entry
+	}
+	entry.lastSeen = now
+	l.sweep(now)
+	return entry.bucket
+}
+
+func (l *RateLimiter) Burst() int {
+	return l.burst
+}
+
+// Clients returns the number of clients tracked.
+func (l *RateLimiter) Clients() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// sweep drops buckets idle for a refill time and refilled to full, at most once per refill time.
+// Buckets in debt from Charge take longer to refill, and are kept until they are.
+func (l *RateLimiter) sweep(now time.Time) {
+	refill := time.Duration(float64(l.burst) / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) < refill {
+		return
+	}
+	l.lastSweep = now
+	for client, entry := range l.buckets {
+		if now.Sub(entry.lastSeen) >= refill && entry.bucket.Full() {
+			delete(l.buckets, client)
+		}
+	}
+}
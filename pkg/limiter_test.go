@@ -0,0 +1,35 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_RateLimiter(t *testing.T) {
+	l := NewRateLimiter(100, 1)
+	ok, _, _ := l.Allow("a")
+	assert.True(t, ok)
+	ok, _, wait := l.Allow("a")
+	assert.False(t, ok)
+	assert.Greater(t, wait, time.Duration(0))
+	// clients are limited separately
+	ok, _, _ = l.Allow("b")
+	assert.True(t, ok)
+	assert.Equal(t, 2, l.Clients())
+	// idle clients are dropped once refilled
+	time.Sleep(20 * time.Millisecond)
+	ok, _, _ = l.Allow("c")
+	assert.True(t, ok)
+	assert.Equal(t, 1, l.Clients())
+
+	// clients in debt are kept until refilled, so the debt isn't forgiven
+	l = NewRateLimiter(100, 1)
+	l.Allow("a")
+	l.Charge("a", 10)
+	time.Sleep(20 * time.Millisecond)
+	l.Allow("b")
+	assert.Equal(t, 2, l.Clients())
+	ok, _, _ = l.Allow("a")
+	assert.False(t, ok)
+}
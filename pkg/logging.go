@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the request ID, which is added to every log line logged with it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request ID and trace ID of the context to records.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestIDFrom(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		r.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values are never logged.
+var sensitiveKeys = map[string]bool{
+	"email":         true,
+	"password":      true,
+	"token":         true,
+	"authorization": true,
+	"cookie":        true,
+	"set-cookie":    true,
+}
+
+// NewLogHandler returns a handler writing JSON or text lines at level, with the request ID of the context.
+// Values of sensitive keys, and any occurrence of secrets in string values, are redacted.
+func NewLogHandler(w io.Writer, json bool, level slog.Leveler, secrets ...string) slog.Handler {
+	replacer := newSecretReplacer(secrets)
+	options := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if sensitiveKeys[strings.ToLower(a.Key)] {
+				return slog.String(a.Key, redacted)
+			}
+			if replacer != nil {
+				switch a.Value.Kind() {
+				case slog.KindString:
+					return slog.String(a.Key, replacer.Replace(a.Value.String()))
+				case slog.KindAny:
+					if err, ok := a.Value.Any().(error); ok {
+						return slog.String(a.Key, replacer.Replace(err.Error()))
+					}
+				}
+			}
+			return a
+		},
+	}
+	if json {
+		return contextHandler{slog.NewJSONHandler(w, options)}
+	}
+	return contextHandler{slog.NewTextHandler(w, options)}
+}
+
+func newSecretReplacer(secrets []string) *strings.Replacer {
+	var pairs []string
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		pairs = append(pairs, secret, redacted)
+		// as sent in forms and urls
+		if escaped := url.QueryEscape(secret); escaped != secret {
+			pairs = append(pairs, escaped, redacted)
+		}
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+	return strings.NewReplacer(pairs...)
+}
@@ -0,0 +1,31 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"testing"
+)
+
+func Test_NewLogHandler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(NewLogHandler(buf, true, slog.LevelInfo, "hunter2", "me@example.com"))
+	ctx := WithRequestID(context.Background(), "req-1")
+
+	logger.DebugContext(ctx, "hidden")
+	assert.Empty(t, buf.String())
+
+	logger.ErrorContext(
+		ctx, "login failed", "password", "secret", "user", "me@example.com",
+		"err", errors.New("bad body: email=me%40example.com&password=hunter2"),
+	)
+	line := map[string]any{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "req-1", line["request_id"])
+	assert.Equal(t, redacted, line["password"])
+	assert.Equal(t, redacted, line["user"])
+	assert.Equal(t, "bad body: email=[REDACTED]&password=[REDACTED]", line["err"])
+}
@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type EvictionPolicy string
+
+const (
+	EvictLRU EvictionPolicy = "lru" // evict the least recently used entry
+	EvictLFU EvictionPolicy = "lfu" // evict the least frequently used entry, the least recently used one on ties
+)
+
+// CacheLimits bounds a cache by entry count and approximate bytes, zero means unlimited.
+type CacheLimits struct {
+	MaxEntries int
+	MaxBytes   int64
+	Eviction   EvictionPolicy
+}
+
+type boundedEntry struct {
+	key      string
+	value    any
+	size     int64
+	uses     uint64
+	expireAt time.Time
+}
+
+// boundedCache is a size bounded map, the most recently used entries are kept at the front of ll.
+type boundedCache struct {
+	limits CacheLimits
+
+	mu        sync.Mutex
+	ll        *list.List
+	items     map[string]*list.Element
+	bytes     int64
+	evictions uint64
+}
+
+func newBoundedCache(limits CacheLimits) *boundedCache {
+	if limits.Eviction == "" {
+		limits.Eviction = EvictLRU
+	}
+	return &boundedCache{
+		limits: limits,
+		ll:     list.New(),
+		items:  make(map[string]*list.Element),
+	}
+}
+
+func (c *boundedCache) get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*boundedEntry)
+	if time.Now().After(entry.expireAt) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	entry.uses++
+	c.ll.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *boundedCache) set(key string, value any, size int64, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+	entry := &boundedEntry{key: key, value: value, size: size, expireAt: time.Now().Add(ttl)}
+	c.items[key] = c.ll.PushFront(entry)
+	c.bytes += size
+	for c.overLimits() && c.ll.Len() > 1 {
+		c.removeElement(c.victim())
+		c.evictions++
+	}
+}
+
+func (c *boundedCache) overLimits() bool {
+	return (c.limits.MaxEntries > 0 && c.ll.Len() > c.limits.MaxEntries) ||
+		(c.limits.MaxBytes > 0 && c.bytes > c.limits.MaxBytes)
+}
+
+// victim never returns the front entry, which is the one just set.
+func (c *boundedCache) victim() *list.Element {
+	victim := c.ll.Back()
+	if c.limits.Eviction != EvictLFU {
+		return victim
+	}
+	for elem := victim.Prev(); elem != c.ll.Front(); elem = elem.Prev() {
+		if elem.Value.(*boundedEntry).uses < victim.Value.(*boundedEntry).uses {
+			victim = elem
+		}
+	}
+	return victim
+}
+
+func (c *boundedCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*boundedEntry)
+	c.ll.Remove(elem)
+	delete(c.items, entry.key)
+	c.bytes -= entry.size
+}
+
+func (c *boundedCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+}
+
+func (c *boundedCache) stats() (entries int, bytes int64, evictions uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len(), c.bytes, c.evictions
+}
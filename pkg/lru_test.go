@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_boundedCache_LRU(t *testing.T) {
+	c := newBoundedCache(CacheLimits{MaxEntries: 2})
+	c.set("a", 1, 1, time.Minute)
+	c.set("b", 2, 1, time.Minute)
+	_, ok := c.get("a")
+	assert.True(t, ok)
+	// b is the least recently used
+	c.set("c", 3, 1, time.Minute)
+	_, ok = c.get("b")
+	assert.False(t, ok)
+	entries, bytes, evictions := c.stats()
+	assert.Equal(t, 2, entries)
+	assert.Equal(t, int64(2), bytes)
+	assert.Equal(t, uint64(1), evictions)
+}
+
+func Test_boundedCache_LFU(t *testing.T) {
+	c := newBoundedCache(CacheLimits{MaxBytes: 30, Eviction: EvictLFU})
+	c.set("a", 1, 10, time.Minute)
+	c.set("b", 2, 10, time.Minute)
+	c.set("c", 3, 10, time.Minute)
+	c.get("a")
+	c.get("a")
+	c.get("b")
+	c.get("c")
+	c.get("c")
+	// b is the least frequently used
+	c.set("d", 4, 10, time.Minute)
+	_, ok := c.get("b")
+	assert.False(t, ok)
+	// an oversized entry evicts everything else
+	c.set("e", 5, 100, time.Minute)
+	entries, bytes, evictions := c.stats()
+	assert.Equal(t, 1, entries)
+	assert.Equal(t, int64(100), bytes)
+	assert.Equal(t, uint64(4), evictions)
+}
+
+func Test_boundedCache_expire(t *testing.T) {
+	c := newBoundedCache(CacheLimits{})
+	c.set("a", 1, 1, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	_, ok := c.get("a")
+	assert.False(t, ok)
+	entries, _, evictions := c.stats()
+	assert.Equal(t, 0, entries)
+	assert.Equal(t, uint64(0), evictions)
+}
@@ -0,0 +1,194 @@
+package pkg
+
+import (
+	"time"
+	_ "time/tzdata" // the market calendar is computed in New York time even without system tzdata
+)
+
+type MarketPhase string
+
+const (
+	PhasePreMarket  MarketPhase = "premarket"  // 04:00 - 09:30 New York
+	PhaseRegular    MarketPhase = "regular"    // 09:30 - 16:00 New York, 13:00 on early close days
+	PhasePostMarket MarketPhase = "postmarket" // until 20:00 New York, 17:00 on early close days
+	PhaseOvernight  MarketPhase = "overnight"  // the rest of trading days
+	PhaseWeekend    MarketPhase = "weekend"
+	PhaseHoliday    MarketPhase = "holiday"
+	PhaseDefault    MarketPhase = "default" // used by Intervals for phases not listed
+)
+
+var newYork, _ = time.LoadLocation("America/New_York")
+
+// ad hoc closures of NYSE and Nasdaq, which can't be derived from rules
+var specialClosures = map[string]string{
+	"2012-10-29": "Hurricane Sandy",
+	"2012-10-30": "Hurricane Sandy",
+	"2018-12-05": "National Day of Mourning for George H.W. Bush",
+	"2025-01-09": "National Day of Mourning for Jimmy Carter",
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, newYork)
+}
+
+// nthWeekday returns the nth weekday of month, or the last one if n is -1.
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int) time.Time {
+	if n < 0 {
+		last := date(year, month+1, 0)
+		return last.AddDate(0, 0, -((int(last.Weekday()) - int(weekday) + 7) % 7))
+	}
+	first := date(year, month, 1)
+	return first.AddDate(0, 0, (int(weekday)-int(first.Weekday())+7)%7+(n-1)*7)
+}
+
+// observed moves a holiday on Saturday to Friday, and on Sunday to Monday.
+func observed(t time.Time) time.Time {
+	switch t.Weekday() {
+	case time.Saturday:
+		return t.AddDate(0, 0, -1)
+	case time.Sunday:
+		return t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// easter computes Easter Sunday of the Gregorian calendar.
+func easter(year int) time.Time {
+	a := year % 19
+	b, c := year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return date(year, time.Month(month), day)
+}
+
+func holidaysOf(year int) map[string]string {
+	holidays := map[string]string{
+		nthWeekday(year, time.January, time.Monday, 3).Format(time.DateOnly):    "Martin Luther King Jr. Day",
+		nthWeekday(year, time.February, time.Monday, 3).Format(time.DateOnly):   "Washington's Birthday",
+		easter(year).AddDate(0, 0, -2).Format(time.DateOnly):                    "Good Friday",
+		nthWeekday(year, time.May, time.Monday, -1).Format(time.DateOnly):       "Memorial Day",
+		observed(date(year, time.July, 4)).Format(time.DateOnly):                "Independence Day",
+		nthWeekday(year, time.September, time.Monday, 1).Format(time.DateOnly):  "Labor Day",
+		nthWeekday(year, time.November, time.Thursday, 4).Format(time.DateOnly): "Thanksgiving Day",
+		observed(date(year, time.December, 25)).Format(time.DateOnly):           "Christmas Day",
+	}
+	// New Year's Day on Saturday is not observed on the Friday before
+	if newYear := date(year, time.January, 1); newYear.Weekday() != time.Saturday {
+		holidays[observed(newYear).Format(time.DateOnly)] = "New Year's Day"
+	}
+	if year >= 2022 {
+		holidays[observed(date(year, time.June, 19)).Format(time.DateOnly)] = "Juneteenth National Independence Day"
+	}
+	return holidays
+}
+
+// Holiday returns the name of the market holiday on the New York date of t, or "" if the market opens.
+func Holiday(t time.Time) string {
+	t = t.In(newYork)
+	day := t.Format(time.DateOnly)
+	if name, ok := specialClosures[day]; ok {
+		return name
+	}
+	return holidaysOf(t.Year())[day]
+}
+
+// IsEarlyClose reports whether the regular session closes at 13:00 on the New York date of t:
+// July 3rd and Christmas Eve from Monday to Thursday, and the day after Thanksgiving.
+func IsEarlyClose(t time.Time) bool {
+	t = t.In(newYork)
+	weekday := t.Weekday()
+	switch {
+	case t.Month() == time.July && t.Day() == 3:
+		return weekday >= time.Monday && weekday <= time.Thursday
+	case t.Month() == time.December && t.Day() == 24:
+		return weekday >= time.Monday && weekday <= time.Thursday
+	case t.Month() == time.November:
+		thanksgiving := nthWeekday(t.Year(), time.November, time.Thursday, 4)
+		return t.Day() == thanksgiving.Day()+1
+	}
+	return false
+}
+
+// IsTradingDay reports whether the market opens on the New York date of t.
+func IsTradingDay(t time.Time) bool {
+	t = t.In(newYork)
+	return t.Weekday() != time.Saturday && t.Weekday() != time.Sunday && Holiday(t) == ""
+}
+
+// sessionOf returns the regular session of the New York date of t.
+func sessionOf(t time.Time) (open time.Time, close time.Time) {
+	t = t.In(newYork)
+	open = time.Date(t.Year(), t.Month(), t.Day(), 9, 30, 0, 0, newYork)
+	close = time.Date(t.Year(), t.Month(), t.Day(), 16, 0, 0, 0, newYork)
+	if IsEarlyClose(t) {
+		close = time.Date(t.Year(), t.Month(), t.Day(), 13, 0, 0, 0, newYork)
+	}
+	return open, close
+}
+
+// MarketPhaseAt returns the phase of US stock market at t.
+func MarketPhaseAt(t time.Time) MarketPhase {
+	t = t.In(newYork)
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return PhaseWeekend
+	}
+	if Holiday(t) != "" {
+		return PhaseHoliday
+	}
+	open, close := sessionOf(t)
+	switch {
+	case t.Before(open.Add(-5*time.Hour - 30*time.Minute)): // 04:00
+		return PhaseOvernight
+	case t.Before(open):
+		return PhasePreMarket
+	case t.Before(close):
+		return PhaseRegular
+	case t.Before(close.Add(4 * time.Hour)):
+		return PhasePostMarket
+	}
+	return PhaseOvernight
+}
+
+type MarketStatus struct {
+	Time       time.Time   `json:"time"`
+	Phase      MarketPhase `json:"phase"`
+	IsOpen     bool        `json:"isOpen"` // in the regular session
+	Holiday    string      `json:"holiday,omitempty"`
+	EarlyClose bool        `json:"earlyClose"`
+	NextOpen   time.Time   `json:"nextOpen"`
+	NextClose  time.Time   `json:"nextClose"`
+}
+
+// MarketStatusAt returns the market status at t, NextOpen and NextClose are the regular session ones.
+func MarketStatusAt(t time.Time) MarketStatus {
+	t = t.In(newYork)
+	status := MarketStatus{
+		Time:       t,
+		Phase:      MarketPhaseAt(t),
+		Holiday:    Holiday(t),
+		EarlyClose: IsTradingDay(t) && IsEarlyClose(t),
+	}
+	status.IsOpen = status.Phase == PhaseRegular
+	for day := t; ; day = day.AddDate(0, 0, 1) {
+		if !IsTradingDay(day) {
+			continue
+		}
+		open, close := sessionOf(day)
+		if status.NextOpen.IsZero() && open.After(t) {
+			status.NextOpen = open
+		}
+		if status.NextClose.IsZero() && close.After(t) {
+			status.NextClose = close
+		}
+		if !status.NextOpen.IsZero() && !status.NextClose.IsZero() {
+			return status
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_MarketPhaseAt(t *testing.T) {
+	for at, phase := range map[string]MarketPhase{
+		"2024-08-23T03:59:00-04:00": PhaseOvernight,
+		"2024-08-23T04:00:00-04:00": PhasePreMarket,
+		"2024-08-23T09:30:00-04:00": PhaseRegular,
+		"2024-08-23T15:59:00-04:00": PhaseRegular,
+		"2024-08-23T16:00:00-04:00": PhasePostMarket,
+		"2024-08-23T20:00:00-04:00": PhaseOvernight,
+		"2024-08-24T12:00:00-04:00": PhaseWeekend,
+		"2024-01-08T14:45:00Z":      PhaseRegular, // 09:45 EST
+		"2024-11-28T12:00:00-05:00": PhaseHoliday, // Thanksgiving
+		"2024-11-29T12:59:00-05:00": PhaseRegular, // early close
+		"2024-11-29T13:00:00-05:00": PhasePostMarket,
+		"2024-11-29T17:00:00-05:00": PhaseOvernight,
+	} {
+		tm, err := time.Parse(time.RFC3339, at)
+		assert.NoError(t, err)
+		assert.Equal(t, phase, MarketPhaseAt(tm), at)
+	}
+}
+
+func Test_Holiday(t *testing.T) {
+	for day, name := range map[string]string{
+		"2021-12-31": "", // New Year's Day on Saturday is not observed
+		"2022-06-20": "Juneteenth National Independence Day",
+		"2021-06-18": "",               // before Juneteenth became a market holiday
+		"2023-01-02": "New Year's Day", // observed on Monday
+		"2024-01-15": "Martin Luther King Jr. Day",
+		"2024-02-19": "Washington's Birthday",
+		"2024-03-29": "Good Friday",
+		"2025-04-18": "Good Friday",
+		"2024-05-27": "Memorial Day",
+		"2026-07-03": "Independence Day", // observed on Friday
+		"2024-09-02": "Labor Day",
+		"2024-11-28": "Thanksgiving Day",
+		"2022-12-26": "Christmas Day",
+		"2025-01-09": "National Day of Mourning for Jimmy Carter",
+		"2024-08-23": "",
+	} {
+		tm, err := time.ParseInLocation(time.DateOnly, day, newYork)
+		assert.NoError(t, err)
+		assert.Equal(t, name, Holiday(tm), day)
+	}
+	for day, early := range map[string]bool{
+		"2024-07-03": true,
+		"2024-11-29": true,
+		"2024-12-24": true,
+		"2026-07-03": false, // holiday instead
+		"2021-12-24": false, // Friday, Christmas observed
+		"2024-08-23": false,
+	} {
+		tm, err := time.ParseInLocation(time.DateOnly, day, newYork)
+		assert.NoError(t, err)
+		assert.Equal(t, early, IsEarlyClose(tm) && IsTradingDay(tm), day)
+	}
+}
+
+func Test_MarketStatusAt(t *testing.T) {
+	// Wednesday before Thanksgiving, after the close
+	tm, _ := time.Parse(time.RFC3339, "2024-11-27T17:00:00-05:00")
+	status := MarketStatusAt(tm)
+	assert.Equal(t, PhasePostMarket, status.Phase)
+	assert.False(t, status.IsOpen)
+	assert.False(t, status.EarlyClose)
+	assert.Equal(t, "2024-11-29T09:30:00-05:00", status.NextOpen.Format(time.RFC3339))
+	assert.Equal(t, "2024-11-29T13:00:00-05:00", status.NextClose.Format(time.RFC3339))
+
+	// in the session, the next open is on the next trading day
+	tm, _ = time.Parse(time.RFC3339, "2024-12-31T10:00:00-05:00")
+	status = MarketStatusAt(tm)
+	assert.True(t, status.IsOpen)
+	assert.Equal(t, "2025-01-02T09:30:00-05:00", status.NextOpen.Format(time.RFC3339))
+	assert.Equal(t, "2024-12-31T16:00:00-05:00", status.NextClose.Format(time.RFC3339))
+
+	tm, _ = time.Parse(time.RFC3339, "2024-07-04T10:00:00-04:00")
+	status = MarketStatusAt(tm)
+	assert.Equal(t, PhaseHoliday, status.Phase)
+	assert.Equal(t, "Independence Day", status.Holiday)
+}
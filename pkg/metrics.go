@@ -0,0 +1,54 @@
+package pkg
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metrics are registered to the default prometheus registry, and served by promhttp.Handler.
+var (
+	upstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "finviz_proxy_upstream_requests_total",
+		Help: "Requests to finviz by endpoint and status code, code is \"error\" for transport errors and \"circuit_open\" if skipped.",
+	}, []string{"endpoint", "code"})
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "finviz_proxy_upstream_request_duration_seconds",
+		Help:    "Time to response headers of finviz requests by endpoint.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"endpoint"})
+	parseFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "finviz_proxy_parse_failures_total",
+		Help: "Failures to parse finviz responses by parser.",
+	}, []string{"parser"})
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "finviz_proxy_cache_requests_total",
+		Help: "Table cache lookups by freshness, fresh and stale are hits, expired and miss are misses.",
+	}, []string{"freshness"})
+	jobRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "finviz_proxy_job_runs_total",
+		Help: "Background job runs by job and result.",
+	}, []string{"job", "result"})
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "finviz_proxy_job_duration_seconds",
+		Help:    "Duration of background job runs by job.",
+		Buckets: []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"job"})
+	eliteLogins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "finviz_proxy_elite_login_attempts_total",
+		Help: "Elite login attempts by result.",
+	}, []string{"result"})
+)
+
+const (
+	resultSuccess   = "success"
+	resultFailure   = "failure"
+	codeError       = "error"
+	codeCircuitOpen = "circuit_open"
+)
+
+func resultOf(err error) string {
+	if err != nil {
+		return resultFailure
+	}
+	return resultSuccess
+}
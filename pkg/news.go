@@ -5,6 +5,8 @@ import (
 	"context"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"log/slog"
 	"net/http"
@@ -12,31 +14,33 @@ import (
 	"time"
 )
 
-func fetchAllNews(ctx context.Context, isElite bool) ([]byte, error) {
+func fetchAllNews(ctx context.Context, isElite bool) (page []byte, err error) {
+	ctx, span := tracer.Start(ctx, "fetchAllNews", trace.WithAttributes(attribute.Bool("finviz.elite", isElite)))
+	defer func() { endSpan(span, err) }()
 	url := "https://finviz.com/news.ashx"
 	if isElite {
 		url = "https://elite.finviz.com/news.ashx"
 	}
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		slog.Error("fetchAllNews http new request", "err", err)
+		slog.ErrorContext(ctx, "fetchAllNews http new request", "err", err)
 		return nil, err
 	}
 	req.Header.Set("User-Agent", "curl/7.88.1")
 	client := newClient()
 	resp, err := client.Do(req)
 	if err != nil {
-		slog.Error("fetchAllNews http do", "err", err)
+		slog.ErrorContext(ctx, "fetchAllNews http do", "err", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		slog.Error("fetchAllNews status code not ok", "code", resp.StatusCode)
+		slog.ErrorContext(ctx, "fetchAllNews status code not ok", "code", resp.StatusCode)
 		return nil, errors.New("fetchAllNews status code not ok")
 	}
-	page, err := io.ReadAll(resp.Body)
+	page, err = io.ReadAll(resp.Body)
 	if err != nil {
-		slog.Error("fetchAllNews read all http resp body", "err", err)
+		slog.ErrorContext(ctx, "fetchAllNews read all http resp body", "err", err)
 		return nil, err
 	}
 	return page, nil
@@ -48,9 +52,9 @@ type Record struct {
 	URL   string `json:"url"`
 }
 
-func parseLinks(table *goquery.Selection) []Record {
-	loc, _ := time.LoadLocation("America/New_York")
-	today := time.Now().UTC().In(loc)
+// parseLinks dates records in New York time, as finviz shows times for today and "Jan-02" for earlier days.
+func parseLinks(table *goquery.Selection, now time.Time) []Record {
+	today := now.In(newYork)
 	var records []Record
 	table.Find("tr.news_table-row").Each(func(i int, tr *goquery.Selection) {
 		a := tr.Find("a")
@@ -62,6 +66,9 @@ func parseLinks(table *goquery.Selection) []Record {
 		if strings.HasSuffix(date, "AM") || strings.HasSuffix(date, "PM") {
 			// if 05:30AM, format today
 			date = today.Format("Jan-02 2006")
+		} else if day, err := time.ParseInLocation("Jan-02 2006", date+" "+today.Format("2006"), newYork); err == nil && day.After(today) {
+			// a day after today is from last year, e.g. Dec-31 listed on Jan-01
+			date = day.AddDate(-1, 0, 0).Format("Jan-02 2006")
 		} else {
 			date += " " + today.Format("2006") // add year
 		}
@@ -75,10 +82,12 @@ func parseLinks(table *goquery.Selection) []Record {
 	return records
 }
 
-func parseNewsAndBlogs(page []byte) ([]Record, []Record, error) {
+func parseNewsAndBlogs(ctx context.Context, page []byte) (news []Record, blogs []Record, err error) {
+	_, span := tracer.Start(ctx, "parseNewsAndBlogs")
+	defer func() { endSpan(span, err) }()
 	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
 	if err != nil {
-		slog.Error("failed to parse news and blogs from page", "err", err)
+		slog.ErrorContext(ctx, "failed to parse news and blogs from page", "err", err)
 		return nil, nil, err
 	}
 	var newsTable *goquery.Selection
@@ -94,21 +103,25 @@ func parseNewsAndBlogs(page []byte) ([]Record, []Record, error) {
 	if newsTable == nil || blogsTable == nil {
 		return nil, nil, errors.New("failed to find news and blogs tables")
 	}
-	return parseLinks(newsTable), parseLinks(blogsTable), nil
+	now := time.Now()
+	news, blogs = parseLinks(newsTable, now), parseLinks(blogsTable, now)
+	span.SetAttributes(attribute.Int("news.count", len(news)), attribute.Int("blogs.count", len(blogs)))
+	return news, blogs, nil
 }
 
 func FetchAndParseNewsAndBlogs(ctx context.Context, isElite bool) ([]Record, []Record, error) {
 	// fetch page
 	page, err := fetchAllNews(ctx, isElite)
 	if err != nil {
-		slog.Error("failed to fetch news and blogs", "err", err)
+		slog.ErrorContext(ctx, "failed to fetch news and blogs", "err", err)
 		return nil, nil, err
 	}
 	// parse table
-	news, blogs, err := parseNewsAndBlogs(page)
+	news, blogs, err := parseNewsAndBlogs(ctx, page)
 	if err != nil {
-		slog.Error("failed to parse news and blogs", "err", err)
-		return nil, nil, err
+		slog.ErrorContext(ctx, "failed to parse news and blogs", "err", err)
+		parseFailures.WithLabelValues("parseNewsAndBlogs").Inc()
+		return nil, nil, &ParseError{Parser: "parseNewsAndBlogs", Err: err}
 	}
 	return news, blogs, nil
 }
@@ -2,9 +2,12 @@ package pkg
 
 import (
 	"context"
+	"github.com/PuerkitoBio/goquery"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_fetchAllNews(t *testing.T) {
@@ -16,8 +19,22 @@ func Test_fetchAllNews(t *testing.T) {
 func Test_parseNewsAndBlogs(t *testing.T) {
 	html, err := os.ReadFile("news.ashx")
 	assert.NoError(t, err)
-	news, blogs, err := parseNewsAndBlogs(html)
+	news, blogs, err := parseNewsAndBlogs(context.Background(), html)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, news)
 	assert.NotEmpty(t, blogs)
 }
+
+func Test_parseLinks(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<table>
+<tr class="news_table-row"><td class="news_date-cell">05:30AM</td><td><a href="https://a">A</a></td></tr>
+<tr class="news_table-row"><td class="news_date-cell">Dec-31</td><td><a href="https://b">B</a></td></tr>
+</table>`))
+	assert.NoError(t, err)
+	// 01:00 on Jan 1st in New York, Dec-31 is from last year
+	now, _ := time.Parse(time.RFC3339, "2025-01-01T06:00:00Z")
+	records := parseLinks(doc.Find("table"), now)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "Jan-01 2025", records[0].Date)
+	assert.Equal(t, "Dec-31 2024", records[1].Date)
+}
@@ -3,12 +3,18 @@ package pkg
 import (
 	"context"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"log/slog"
 	"net/http"
 )
 
-func fetchFinvizPage(ctx context.Context, params string, isElite bool) ([]byte, error) {
+func fetchFinvizPage(ctx context.Context, params string, isElite bool) (page []byte, err error) {
+	ctx, span := tracer.Start(ctx, "fetchFinvizPage", trace.WithAttributes(
+		attribute.String("finviz.params", params), attribute.Bool("finviz.elite", isElite),
+	))
+	defer func() { endSpan(span, err) }()
 	baseUrl := "https://finviz.com/screener.ashx?"
 	if isElite {
 		baseUrl = "https://elite.finviz.com/screener.ashx?"
@@ -18,24 +24,24 @@ func fetchFinvizPage(ctx context.Context, params string, isElite bool) ([]byte,
 		ctx, http.MethodGet, baseUrl+params, nil,
 	)
 	if err != nil {
-		slog.Error("fetchFinvizPage http new request", "err", err)
+		slog.ErrorContext(ctx, "fetchFinvizPage http new request", "err", err)
 		return nil, err
 	}
 	req.Header.Set("User-Agent", "curl/7.88.1")
 	client := newClient()
 	resp, err := client.Do(req)
 	if err != nil {
-		slog.Error("fetchFinvizPage http do", "err", err)
+		slog.ErrorContext(ctx, "fetchFinvizPage http do", "err", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		slog.Error("fetchFinvizPage status code not ok", "code", resp.StatusCode)
+		slog.ErrorContext(ctx, "fetchFinvizPage status code not ok", "code", resp.StatusCode)
 		return nil, errors.New("fetchFinvizPage status code not ok")
 	}
-	page, err := io.ReadAll(resp.Body)
+	page, err = io.ReadAll(resp.Body)
 	if err != nil {
-		slog.Error("fetchFinvizPage read all http resp body", "err", err)
+		slog.ErrorContext(ctx, "fetchFinvizPage read all http resp body", "err", err)
 		return nil, err
 	}
 	return page, nil
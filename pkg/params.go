@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"regexp"
 	"strings"
+	"sync"
 )
 
 type FilterOption struct {
@@ -36,6 +37,9 @@ type Params struct {
 	Filters []Filter `json:"filters"`
 	Sorters []Sorter `json:"sorters"`
 	Signals []Signal `json:"signals"`
+
+	indexOnce sync.Once
+	index     *ParamsIndex
 }
 
 func parseKeyValuePairs(str string) map[string]string {
@@ -150,10 +154,12 @@ func parseFilterOptions(selection *goquery.Selection) []FilterOption {
 	return options
 }
 
-func parseFilters(doc *goquery.Document) ([]Filter, error) {
+func parseFilters(ctx context.Context, doc *goquery.Document) (filters []Filter, err error) {
+	_, span := tracer.Start(ctx, "parseFilters")
+	defer func() { endSpan(span, err) }()
 	table := doc.Find("table#filter-table-filters").First()
 	if table == nil || table.Length() == 0 {
-		slog.Error("table not found")
+		slog.ErrorContext(ctx, "table not found")
 		return nil, errors.New("table not found")
 	}
 	// parse filters, each filter is a meta and an option
@@ -173,10 +179,10 @@ func parseFilters(doc *goquery.Document) ([]Filter, error) {
 		selections = append(selections, selection)
 	})
 	if len(spans) != len(selections) {
-		slog.Error("len(spans) != len(selections)", "len(spans)", len(spans), "len(selections)", len(selections))
+		slog.ErrorContext(ctx, "len(spans) != len(selections)", "len(spans)", len(spans), "len(selections)", len(selections))
 		return nil, errors.New("len(spans) != len(selections)")
 	}
-	filters := make([]Filter, 0)
+	filters = make([]Filter, 0)
 	// parse meta and selections to get filters
 	for i := 0; i < len(spans); i++ {
 		span := spans[i]
@@ -184,13 +190,13 @@ func parseFilters(doc *goquery.Document) ([]Filter, error) {
 		name, description := parseFilterNameAndDescription(span)
 		id_, ok := selection.Attr("id")
 		if !ok {
-			slog.Warn("id not found in selection", "selection", selection)
+			slog.WarnContext(ctx, "id not found in selection", "selection", selection)
 			continue
 		}
 		if name != "" {
 			options := parseFilterOptions(selection)
 			if len(options) > 0 {
-				slog.Debug("Filter Added", "Index", i, "Name", name, "Description", description, "Options", options)
+				slog.DebugContext(ctx, "Filter Added", "Index", i, "Name", name, "Description", description, "Options", options)
 				filters = append(filters, Filter{
 					Id:          id_,
 					Name:        name,
@@ -249,31 +255,34 @@ func parseSignals(doc *goquery.Document) ([]Signal, error) {
 func FetchParams(ctx context.Context, isElite bool) (*Params, error) {
 	page, err := fetchFinvizPage(ctx, "ft=4", isElite)
 	if err != nil {
-		slog.Error("failed to fetch page", "err", err)
+		slog.ErrorContext(ctx, "failed to fetch page", "err", err)
 		return nil, err
 	}
 
 	// parse params from page
 	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
 	if err != nil {
-		slog.Error("failed to parse page", "err", err)
+		slog.ErrorContext(ctx, "failed to parse page", "err", err)
 		return nil, err
 	}
 	params := &Params{}
-	params.Filters, err = parseFilters(doc)
+	params.Filters, err = parseFilters(ctx, doc)
 	if err != nil {
-		slog.Error("failed to parse filters", "err", err)
-		return nil, err
+		slog.ErrorContext(ctx, "failed to parse filters", "err", err)
+		parseFailures.WithLabelValues("parseFilters").Inc()
+		return nil, &ParseError{Parser: "parseFilters", Err: err}
 	}
 	params.Sorters, err = parseSorters(doc)
 	if err != nil {
-		slog.Error("failed to parse sorters", "err", err)
-		return nil, err
+		slog.ErrorContext(ctx, "failed to parse sorters", "err", err)
+		parseFailures.WithLabelValues("parseSorters").Inc()
+		return nil, &ParseError{Parser: "parseSorters", Err: err}
 	}
 	params.Signals, err = parseSignals(doc)
 	if err != nil {
-		slog.Error("failed to parse signals", "err", err)
-		return nil, err
+		slog.ErrorContext(ctx, "failed to parse signals", "err", err)
+		parseFailures.WithLabelValues("parseSignals").Inc()
+		return nil, &ParseError{Parser: "parseSignals", Err: err}
 	}
 	return params, nil
 }
@@ -0,0 +1,60 @@
+package pkg
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type persistedSnapshot struct {
+	FetchedAt time.Time       `json:"fetchedAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// SaveSnapshot writes data as dir/name.json, through a temp file and rename so readers never see a partial file.
+func SaveSnapshot(dir string, name string, data any, fetchedAt time.Time) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(&persistedSnapshot{FetchedAt: fetchedAt, Data: raw})
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		slog.Error("failed to create data dir", "dir", dir, "err", err)
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		slog.Error("failed to create temp snapshot", "dir", dir, "err", err)
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name+".json"))
+}
+
+// LoadSnapshot reads dir/name.json saved by SaveSnapshot into data, and returns when it was fetched.
+func LoadSnapshot(dir string, name string, data any) (time.Time, error) {
+	content, err := os.ReadFile(filepath.Join(dir, name+".json"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	snapshot := &persistedSnapshot{}
+	if err = json.Unmarshal(content, snapshot); err != nil {
+		return time.Time{}, err
+	}
+	if err = json.Unmarshal(snapshot.Data, data); err != nil {
+		return time.Time{}, err
+	}
+	return snapshot.FetchedAt, nil
+}
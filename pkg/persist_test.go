@@ -0,0 +1,29 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_SaveAndLoadSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	futures := map[string]FutureQuota{"ES": {Label: "S&P 500", Ticker: "ES", Last: 5000.25}}
+	fetchedAt := time.Now().Add(-time.Minute).Round(0)
+	assert.NoError(t, SaveSnapshot(dir, "futures", futures, fetchedAt))
+
+	loaded := map[string]FutureQuota{}
+	loadedAt, err := LoadSnapshot(dir, "futures", &loaded)
+	assert.NoError(t, err)
+	assert.Equal(t, futures, loaded)
+	assert.True(t, fetchedAt.Equal(loadedAt))
+
+	// no temp file is left behind
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	_, err = LoadSnapshot(dir, "params", &Params{})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
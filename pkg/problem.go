@@ -0,0 +1,153 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Codes of problems and params errors, stable for clients to match on.
+const (
+	CodeInvalidParams       = "invalid_params" // one or more params errors, listed in Problem.Errors
+	CodeInvalidKey          = "invalid_key"
+	CodeInvalidOrder        = "invalid_order"
+	CodeInvalidDesc         = "invalid_desc"
+	CodeInvalidSignal       = "invalid_signal"
+	CodeInvalidFilter       = "invalid_filter"
+	CodeDuplicateFilter     = "duplicate_filter"
+	CodeInvalidBody         = "invalid_body"
+	CodeInvalidRange        = "invalid_range"
+	CodeEliteRequired       = "elite_required"
+	CodeUnknownSymbol       = "unknown_symbol"
+	CodeInvalidTicker       = "invalid_ticker"
+	CodeTooManyTickers      = "too_many_tickers"
+	CodeNotLoaded           = "not_loaded"
+	CodeUpstreamUnavailable = "upstream_unavailable"
+	CodeUpstreamTimeout     = "upstream_timeout"
+	CodeParseFailed         = "parse_failed"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeRateLimited         = "rate_limited"
+	CodeQuotaExhausted      = "quota_exhausted"
+	CodeNotFound            = "not_found"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeInternalError       = "internal_error"
+)
+
+// Problem is an RFC 7807 problem details object, served as application/problem+json.
+type Problem struct {
+	Type     string         `json:"type"`
+	Title    string         `json:"title"`
+	Status   int            `json:"status"`
+	Detail   string         `json:"detail,omitempty"`
+	Instance string         `json:"instance,omitempty"`
+	Code     string         `json:"code"`
+	Errors   []*ParamsError `json:"errors,omitempty"`
+}
+
+func NewProblem(status int, code string, title string, detail string) *Problem {
+	return &Problem{
+		Type:   "urn:finviz-proxy:problem:" + code,
+		Title:  title,
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return p.Title
+	}
+	return p.Title + ": " + p.Detail
+}
+
+// ParamsError is an invalid param of a request, with the nearest valid values if any.
+type ParamsError struct {
+	Code        string   `json:"code"`
+	Param       string   `json:"param"`
+	Value       string   `json:"value"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+func NewParamsError(code string, param string, value string) *ParamsError {
+	return &ParamsError{
+		Code:  code,
+		Param: param,
+		Value: value,
+	}
+}
+
+// WithSuggestions sets the suggestions of p and returns it.
+func (p *ParamsError) WithSuggestions(suggestions []string) *ParamsError {
+	p.Suggestions = suggestions
+	return p
+}
+
+func (p *ParamsError) Error() string {
+	if len(p.Suggestions) > 0 {
+		return fmt.Sprintf("%s: %s=%s, did you mean %s?", p.Code, p.Param, p.Value, strings.Join(p.Suggestions, " or "))
+	}
+	return fmt.Sprintf("%s: %s=%s", p.Code, p.Param, p.Value)
+}
+
+// ParamsErrors are all invalid params of a request, reported at once.
+type ParamsErrors []*ParamsError
+
+func (p ParamsErrors) Error() string {
+	msgs := make([]string, 0, len(p))
+	for _, err := range p {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// sorted orders errors by param and value, as params from maps are checked in random order.
+func (p ParamsErrors) sorted() ParamsErrors {
+	sort.SliceStable(p, func(i, j int) bool {
+		if p[i].Param != p[j].Param {
+			return p[i].Param < p[j].Param
+		}
+		return p[i].Value < p[j].Value
+	})
+	return p
+}
+
+func IsParamsError(err error) bool {
+	var paramsErrors ParamsErrors
+	var paramsError *ParamsError
+	return errors.As(err, &paramsErrors) || errors.As(err, &paramsError)
+}
+
+// ParamsProblem returns a 400 problem listing the params errors of err.
+func ParamsProblem(err error) *Problem {
+	var paramsErrors ParamsErrors
+	var paramsError *ParamsError
+	if !errors.As(err, &paramsErrors) && errors.As(err, &paramsError) {
+		paramsErrors = ParamsErrors{paramsError}
+	}
+	problem := NewProblem(http.StatusBadRequest, CodeInvalidParams, "Invalid params", fmt.Sprintf("%d invalid params", len(paramsErrors)))
+	problem.Errors = paramsErrors
+	return problem
+}
+
+// ParseError is returned when a finviz page can't be parsed, usually because finviz changed its layout.
+type ParseError struct {
+	Parser string
+	Err    error
+}
+
+func (p *ParseError) Error() string {
+	return p.Parser + ": " + p.Err.Error()
+}
+
+func (p *ParseError) Unwrap() error {
+	return p.Err
+}
+
+func IsParseError(err error) bool {
+	var parseError *ParseError
+	return errors.As(err, &parseError)
+}
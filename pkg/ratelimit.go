@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucket allows bursts of up to burst requests, refilled at rate requests per second.
+type TokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = max(1, int(math.Ceil(rate)))
+	}
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Take takes a token if any, and returns the tokens remaining, and how long until the next token.
+func (b *TokenBucket) Take() (ok bool, remaining int, wait time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	}
+	remaining, wait = b.state()
+	return ok, remaining, wait
+}
+
+// Charge takes n tokens for the extra cost of a request already allowed, the bucket can go into debt,
+// which later requests wait for.
+func (b *TokenBucket) Charge(n int) (remaining int, wait time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	b.tokens -= float64(n)
+	return b.state()
+}
+
+func (b *TokenBucket) refill() {
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+func (b *TokenBucket) state() (remaining int, wait time.Duration) {
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	return max(0, int(b.tokens)), wait
+}
+
+// Full reports whether the bucket has refilled to burst, so dropping it forgives no debt.
+func (b *TokenBucket) Full() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens+time.Since(b.last).Seconds()*b.rate >= b.burst
+}
+
+func (b *TokenBucket) Burst() int {
+	return int(b.burst)
+}
+
+// DailyQuota counts requests of the current UTC day, up to limit.
+type DailyQuota struct {
+	limit int64
+
+	mu   sync.Mutex
+	day  time.Time
+	used int64
+}
+
+func NewDailyQuota(limit int64) *DailyQuota {
+	return &DailyQuota{limit: limit}
+}
+
+// Take counts a request if the quota of today is not used up, and returns the requests remaining and when it resets.
+func (q *DailyQuota) Take() (ok bool, remaining int64, reset time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	if !today.Equal(q.day) {
+		q.day, q.used = today, 0
+	}
+	reset = today.Add(24 * time.Hour)
+	if q.used >= q.limit {
+		return false, 0, reset
+	}
+	q.used++
+	return true, q.limit - q.used, reset
+}
+
+func (q *DailyQuota) Limit() int64 {
+	return q.limit
+}
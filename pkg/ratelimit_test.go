@@ -0,0 +1,50 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_TokenBucket(t *testing.T) {
+	b := NewTokenBucket(50, 2)
+	ok, remaining, _ := b.Take()
+	assert.True(t, ok)
+	assert.Equal(t, 1, remaining)
+	ok, _, _ = b.Take()
+	assert.True(t, ok)
+	ok, remaining, wait := b.Take()
+	assert.False(t, ok)
+	assert.Equal(t, 0, remaining)
+	assert.InDelta(t, 20*time.Millisecond, wait, float64(5*time.Millisecond))
+	// refilled at 50 per second
+	time.Sleep(30 * time.Millisecond)
+	ok, _, _ = b.Take()
+	assert.True(t, ok)
+
+	// charges go into debt, which takes a while to pay back
+	b = NewTokenBucket(50, 2)
+	b.Take()
+	remaining, wait = b.Charge(3)
+	assert.Equal(t, 0, remaining)
+	assert.InDelta(t, 60*time.Millisecond, wait, float64(5*time.Millisecond))
+	ok, _, _ = b.Take()
+	assert.False(t, ok)
+}
+
+func Test_DailyQuota(t *testing.T) {
+	q := NewDailyQuota(2)
+	ok, remaining, reset := q.Take()
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), remaining)
+	assert.Equal(t, time.Now().UTC().Truncate(24*time.Hour).Add(24*time.Hour), reset)
+	ok, _, _ = q.Take()
+	assert.True(t, ok)
+	ok, remaining, _ = q.Take()
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), remaining)
+	// a new day resets the quota
+	q.day = q.day.Add(-24 * time.Hour)
+	ok, _, _ = q.Take()
+	assert.True(t, ok)
+}
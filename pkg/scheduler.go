@@ -0,0 +1,252 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Intervals are refresh intervals by market phase, as "regular:1m,weekend:30m,default:5m".
+type Intervals map[MarketPhase]time.Duration
+
+// At returns the interval of the market phase at t, holidays fall back to weekend, then to default.
+func (i Intervals) At(t time.Time) time.Duration {
+	phase := MarketPhaseAt(t)
+	if interval, ok := i[phase]; ok {
+		return interval
+	}
+	if interval, ok := i[PhaseWeekend]; ok && phase == PhaseHoliday {
+		return interval
+	}
+	return i[PhaseDefault]
+}
+
+func (i Intervals) validate() error {
+	for _, phase := range []MarketPhase{PhasePreMarket, PhaseRegular, PhasePostMarket, PhaseOvernight, PhaseWeekend} {
+		if _, ok := i[phase]; !ok && i[PhaseDefault] <= 0 {
+			return fmt.Errorf("no interval for %s and no default", phase)
+		}
+	}
+	for phase, interval := range i {
+		if interval <= 0 {
+			return fmt.Errorf("interval of %s should be positive", phase)
+		}
+	}
+	return nil
+}
+
+type Job struct {
+	Name      string
+	Intervals Intervals
+	Jitter    time.Duration // random delay added to every interval, so replicas don't refresh in lockstep
+	Timeout   time.Duration
+	Backoff   time.Duration // first retry delay after a failure, doubled on each failure up to the interval
+	After     string        // name of the job which must succeed once before this job starts
+	Run       func(ctx context.Context) error
+}
+
+type JobStatus struct {
+	Name                string    `json:"name"`
+	LastRun             time.Time `json:"lastRun"`
+	LastSuccess         time.Time `json:"lastSuccess"`
+	LastError           string    `json:"lastError,omitempty"`
+	LastDuration        string    `json:"lastDuration"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	NextRun             time.Time `json:"nextRun"`
+}
+
+type triggeredKey struct{}
+
+// IsTriggered reports whether ctx is of a run triggered by Scheduler.Trigger, which should skip caches.
+func IsTriggered(ctx context.Context) bool {
+	triggered, _ := ctx.Value(triggeredKey{}).(bool)
+	return triggered
+}
+
+type scheduledJob struct {
+	Job
+	trigger   chan struct{}
+	succeeded chan struct{} // closed on the first success
+
+	mu     sync.Mutex
+	status JobStatus
+}
+
+// Scheduler runs registered jobs in background, each at the interval of the current market phase.
+// All jobs should be registered before Run.
+type Scheduler struct {
+	mu   sync.Mutex
+	jobs map[string]*scheduledJob
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{jobs: make(map[string]*scheduledJob)}
+}
+
+func (s *Scheduler) Register(job Job) error {
+	if err := job.Intervals.validate(); err != nil {
+		return fmt.Errorf("job %s: %w", job.Name, err)
+	}
+	if job.Timeout <= 0 {
+		job.Timeout = time.Minute
+	}
+	if job.Backoff <= 0 {
+		job.Backoff = time.Second
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[job.Name]; ok {
+		return fmt.Errorf("job %s is already registered", job.Name)
+	}
+	s.jobs[job.Name] = &scheduledJob{
+		Job:       job,
+		trigger:   make(chan struct{}, 1),
+		succeeded: make(chan struct{}),
+		status:    JobStatus{Name: job.Name},
+	}
+	return nil
+}
+
+// Run starts all jobs and blocks until ctx is done and every job returned.
+func (s *Scheduler) Run(ctx context.Context) error {
+	s.mu.Lock()
+	for _, job := range s.jobs {
+		if _, ok := s.jobs[job.After]; job.After != "" && !ok {
+			s.mu.Unlock()
+			return fmt.Errorf("job %s runs after unknown job %s", job.Name, job.After)
+		}
+	}
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		wg.Add(1)
+		go func(job *scheduledJob) {
+			defer wg.Done()
+			s.loop(ctx, job)
+		}(job)
+	}
+	s.mu.Unlock()
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Trigger runs the job now instead of waiting for its next run.
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.Lock()
+	job, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown job: %s", name)
+	}
+	select {
+	case job.trigger <- struct{}{}:
+	default: // already triggered
+	}
+	return nil
+}
+
+func (s *Scheduler) Statuses() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]JobStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		job.mu.Lock()
+		ret = append(ret, job.status)
+		job.mu.Unlock()
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+func (s *Scheduler) Status(name string) (JobStatus, bool) {
+	s.mu.Lock()
+	job, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return JobStatus{}, false
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.status, true
+}
+
+func (s *Scheduler) loop(ctx context.Context, job *scheduledJob) {
+	if job.After != "" {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.jobs[job.After].succeeded:
+		}
+	}
+	backoff := job.Backoff
+	triggered := false
+	for {
+		delay := job.Intervals.At(time.Now())
+		if err := s.runOnce(ctx, job, triggered); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			delay = min(backoff, delay)
+			backoff *= 2
+		} else {
+			backoff = job.Backoff
+			if job.Jitter > 0 {
+				delay += time.Duration(rand.Int63n(int64(job.Jitter)))
+			}
+		}
+		job.mu.Lock()
+		job.status.NextRun = time.Now().Add(delay)
+		job.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-job.trigger:
+			slog.InfoContext(ctx, "job triggered", "job", job.Name)
+			timer.Stop()
+			triggered = true
+		case <-timer.C:
+			triggered = false
+		}
+	}
+}
+
+func (s *Scheduler) runOnce(ctx context.Context, job *scheduledJob, triggered bool) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, job.Timeout)
+	defer cancel()
+	if triggered {
+		ctx = context.WithValue(ctx, triggeredKey{}, true)
+	}
+	ctx, span := tracer.Start(ctx, "job "+job.Name)
+	defer func() { endSpan(span, err) }()
+	slog.InfoContext(ctx, "running job...", "job", job.Name, "phase", MarketPhaseAt(time.Now()))
+	start := time.Now()
+	err = job.Run(ctx)
+
+	jobRuns.WithLabelValues(job.Name, resultOf(err)).Inc()
+	jobDuration.WithLabelValues(job.Name).Observe(time.Since(start).Seconds())
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.status.LastRun = start
+	job.status.LastDuration = time.Since(start).String()
+	if err != nil {
+		slog.ErrorContext(ctx, "job err", "job", job.Name, "err", err)
+		job.status.LastError = err.Error()
+		job.status.ConsecutiveFailures++
+		return err
+	}
+	slog.InfoContext(ctx, "job success", "job", job.Name)
+	if job.status.LastSuccess.IsZero() {
+		close(job.succeeded)
+	}
+	job.status.LastSuccess = start
+	job.status.LastError = ""
+	job.status.ConsecutiveFailures = 0
+	return nil
+}
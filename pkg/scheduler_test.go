@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_Intervals(t *testing.T) {
+	intervals := Intervals{PhaseWeekend: time.Hour, PhaseDefault: time.Minute}
+	saturday, _ := time.Parse(time.RFC3339, "2024-08-24T12:00:00-04:00")
+	assert.Equal(t, time.Hour, intervals.At(saturday))
+	assert.Equal(t, time.Minute, intervals.At(saturday.Add(-24*time.Hour)))
+	thanksgiving, _ := time.Parse(time.RFC3339, "2024-11-28T12:00:00-05:00")
+	assert.Equal(t, time.Hour, intervals.At(thanksgiving))
+	assert.Error(t, Intervals{PhaseRegular: time.Minute}.validate())
+}
+
+func Test_Scheduler(t *testing.T) {
+	s := NewScheduler()
+	var logins, refreshes atomic.Int32
+	assert.NoError(t, s.Register(Job{
+		Name:      "login",
+		Intervals: Intervals{PhaseDefault: time.Hour},
+		Backoff:   10 * time.Millisecond,
+		Run: func(ctx context.Context) error {
+			// fails twice before success
+			if logins.Add(1) < 3 {
+				return errors.New("login failed")
+			}
+			return nil
+		},
+	}))
+	assert.NoError(t, s.Register(Job{
+		Name:      "refresh",
+		Intervals: Intervals{PhaseDefault: time.Hour},
+		After:     "login",
+		Run: func(ctx context.Context) error {
+			assert.Equal(t, int32(3), logins.Load())
+			refreshes.Add(1)
+			return nil
+		},
+	}))
+	assert.Error(t, s.Register(Job{Name: "refresh", Intervals: Intervals{PhaseDefault: time.Hour}}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+	assert.Eventually(t, func() bool { return refreshes.Load() == 1 }, time.Second, 5*time.Millisecond)
+
+	assert.NoError(t, s.Trigger("refresh"))
+	assert.Eventually(t, func() bool { return refreshes.Load() == 2 }, time.Second, 5*time.Millisecond)
+	assert.Error(t, s.Trigger("unknown"))
+
+	status, ok := s.Status("login")
+	assert.True(t, ok)
+	assert.Equal(t, 0, status.ConsecutiveFailures)
+	assert.False(t, status.LastSuccess.IsZero())
+	assert.True(t, status.NextRun.After(time.Now().Add(59*time.Minute)))
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/propagation"
 	"log/slog"
 	"net/http"
 	"net/http/cookiejar"
@@ -17,17 +19,25 @@ func newClient() *http.Client {
 	return &http.Client{
 		Jar:     cookies,
 		Timeout: time.Minute,
+		// trace finviz requests, without propagating our trace context to finviz
+		Transport: otelhttp.NewTransport(
+			&breakerTransport{next: http.DefaultTransport},
+			otelhttp.WithPropagators(propagation.NewCompositeTextMapPropagator()),
+		),
 	}
 }
 
-func EliteLogin(ctx context.Context, email string, password string) (bool, error) {
+func EliteLogin(ctx context.Context, email string, password string) (ok bool, err error) {
+	defer func() {
+		eliteLogins.WithLabelValues(resultOf(err)).Inc()
+	}()
 	// build request
 	req, err := http.NewRequestWithContext(
 		ctx, http.MethodPost, "https://finviz.com/login_submit.ashx",
 		bytes.NewReader([]byte(url.PathEscape(fmt.Sprintf("email=%s&password=%s", email, password)))),
 	)
 	if err != nil {
-		slog.Error("login http new request failed", "err", err)
+		slog.ErrorContext(ctx, "login http new request failed", "err", err)
 		return false, err
 	}
 	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:126.0) Gecko/20100101 Firefox/126.0")
@@ -36,24 +46,30 @@ func EliteLogin(ctx context.Context, email string, password string) (bool, error
 	client := newClient()
 	resp, err := client.Do(req)
 	if err != nil {
-		slog.Error("login http do failed", "err", err)
+		slog.ErrorContext(ctx, "login http do failed", "err", err)
 		return false, err
 	}
 	defer resp.Body.Close()
 	// check response
 	if resp.StatusCode != http.StatusOK {
-		slog.Error("login http status != 200", "status", resp.StatusCode)
+		slog.ErrorContext(ctx, "login http status != 200", "status", resp.StatusCode)
 		return false, fmt.Errorf("login http status: %d", resp.StatusCode)
 	}
 	// check new request URL
 	req = resp.Request
 	if req == nil {
-		slog.Error("login http redirect request is nil")
+		slog.ErrorContext(ctx, "login http redirect request is nil")
 		return false, fmt.Errorf("login http redirect request is nil")
 	}
 	if req.URL.Host != "elite.finviz.com" {
-		slog.Error("login http redirect url host != elite.finviz.com", "host", req.URL.Host)
+		slog.ErrorContext(ctx, "login http redirect url host != elite.finviz.com", "host", req.URL.Host)
 		return false, fmt.Errorf("login http redirect url host: %s", req.URL.Host)
 	}
 	return true, nil
 }
+
+// HasEliteSession reports whether the cookie jar holds unexpired cookies of elite.finviz.com.
+func HasEliteSession() bool {
+	u, _ := url.Parse("https://elite.finviz.com/")
+	return len(cookies.Cookies(u)) > 0
+}
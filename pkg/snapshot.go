@@ -0,0 +1,42 @@
+package pkg
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot is an immutable version of a dataset, never modify it after it is stored.
+type Snapshot[T any] struct {
+	Data      T         `json:"data"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	Version   uint64    `json:"version"`
+	Stale     bool      `json:"stale"` // loaded from disk and not refreshed from finviz yet
+}
+
+// SnapshotStore holds the current snapshot of a dataset, readers load it atomically without locking,
+// and writers swap in a whole new snapshot with the next version.
+type SnapshotStore[T any] struct {
+	mu      sync.Mutex
+	current atomic.Pointer[Snapshot[T]]
+}
+
+// Load returns the current snapshot, or nil if nothing is stored yet.
+func (s *SnapshotStore[T]) Load() *Snapshot[T] {
+	return s.current.Load()
+}
+
+func (s *SnapshotStore[T]) Loaded() bool {
+	return s.current.Load() != nil
+}
+
+func (s *SnapshotStore[T]) Store(data T, fetchedAt time.Time, stale bool) *Snapshot[T] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := &Snapshot[T]{Data: data, FetchedAt: fetchedAt, Version: 1, Stale: stale}
+	if current := s.current.Load(); current != nil {
+		snapshot.Version = current.Version + 1
+	}
+	s.current.Store(snapshot)
+	return snapshot
+}
@@ -0,0 +1,43 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_SnapshotStore(t *testing.T) {
+	store := &SnapshotStore[[]Record]{}
+	assert.False(t, store.Loaded())
+	assert.Nil(t, store.Load())
+
+	first := store.Store([]Record{{Title: "first"}}, time.Now(), true)
+	assert.Equal(t, uint64(1), first.Version)
+	assert.True(t, store.Load().Stale)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				store.Store([]Record{{Title: "next"}}, time.Now(), false)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			last := uint64(0)
+			for j := 0; j < 100; j++ {
+				snapshot := store.Load()
+				assert.Len(t, snapshot.Data, 1)
+				assert.GreaterOrEqual(t, snapshot.Version, last)
+				last = snapshot.Version
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(801), store.Load().Version)
+	// the stored snapshots are never modified
+	assert.Equal(t, "first", first.Data[0].Title)
+}
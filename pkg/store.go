@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"context"
+	"time"
+)
+
+// CacheStore keeps encoded values by key until their ttl, it backs the table cache and the background datasets.
+type CacheStore interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Purge(ctx context.Context) error
+	Stats(ctx context.Context) (StoreStats, error)
+	Close() error
+}
+
+type StoreStats struct {
+	Backend    string `json:"backend"`
+	Entries    int    `json:"entries"`
+	Bytes      int64  `json:"bytes"`
+	MaxEntries int    `json:"maxEntries,omitempty"`
+	MaxBytes   int64  `json:"maxBytes,omitempty"`
+	Evictions  uint64 `json:"evictions"`
+}
+
+// MemoryStore keeps values in process memory, bounded by CacheLimits.
+type MemoryStore struct {
+	items *boundedCache
+}
+
+func NewMemoryStore(limits CacheLimits) *MemoryStore {
+	return &MemoryStore{items: newBoundedCache(limits)}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	v, found := s.items.get(key)
+	if !found {
+		return nil, false, nil
+	}
+	return v.([]byte), true, nil
+}
+
+func (s *MemoryStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.items.set(key, value, int64(len(key)+len(value)), ttl)
+	return nil
+}
+
+func (s *MemoryStore) Purge(ctx context.Context) error {
+	s.items.purge()
+	return nil
+}
+
+func (s *MemoryStore) Stats(ctx context.Context) (StoreStats, error) {
+	entries, bytes, evictions := s.items.stats()
+	return StoreStats{
+		Backend:    "memory",
+		Entries:    entries,
+		Bytes:      bytes,
+		MaxEntries: s.items.limits.MaxEntries,
+		MaxBytes:   s.items.limits.MaxBytes,
+		Evictions:  evictions,
+	}, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
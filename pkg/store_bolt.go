@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"context"
+	"encoding/binary"
+	"go.etcd.io/bbolt"
+	"log/slog"
+	"time"
+)
+
+var boltBucket = []byte("cache")
+
+// BoltStore keeps values in an embedded bbolt file, so a restarted instance starts warm.
+// Each value is prefixed by its expiration in unix nanoseconds, expired values are dropped by a janitor.
+type BoltStore struct {
+	db   *bbolt.DB
+	done chan struct{}
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		slog.Error("failed to open bolt store", "path", path, "err", err)
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		slog.Error("failed to create bolt bucket", "err", err)
+		db.Close()
+		return nil, err
+	}
+	s := &BoltStore{db: db, done: make(chan struct{})}
+	go s.janitor(time.Minute)
+	return s, nil
+}
+
+func (s *BoltStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	var value []byte
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get([]byte(key))
+		if len(v) < 8 || isExpired(v, time.Now()) {
+			return nil
+		}
+		value = append([]byte(nil), v[8:]...)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return value, value != nil, nil
+}
+
+func (s *BoltStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	v := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(v, uint64(time.Now().Add(ttl).UnixNano()))
+	copy(v[8:], value)
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), v)
+	})
+}
+
+func (s *BoltStore) Purge(ctx context.Context) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket(boltBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(boltBucket)
+		return err
+	})
+}
+
+func (s *BoltStore) Stats(ctx context.Context) (StoreStats, error) {
+	stats := StoreStats{Backend: "bolt"}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			stats.Entries++
+			stats.Bytes += int64(len(k) + len(v))
+			return nil
+		})
+	})
+	return stats, err
+}
+
+func (s *BoltStore) Close() error {
+	close(s.done)
+	return s.db.Close()
+}
+
+func isExpired(v []byte, now time.Time) bool {
+	return int64(binary.BigEndian.Uint64(v)) < now.UnixNano()
+}
+
+func (s *BoltStore) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		err := s.db.Update(func(tx *bbolt.Tx) error {
+			now := time.Now()
+			cursor := tx.Bucket(boltBucket).Cursor()
+			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				if len(v) < 8 || isExpired(v, now) {
+					if err := cursor.Delete(); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			slog.Error("failed to drop expired values from bolt store", "err", err)
+		}
+	}
+}
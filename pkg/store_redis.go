@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"time"
+)
+
+// RedisStore keeps values in redis under a key prefix, so several replicas share one cache.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisStore(ctx context.Context, options *redis.Options, prefix string) (*RedisStore, error) {
+	client := redis.NewClient(options)
+	if err := client.Ping(ctx).Err(); err != nil {
+		slog.ErrorContext(ctx, "failed to ping redis", "addr", options.Addr, "err", err)
+		client.Close()
+		return nil, err
+	}
+	return &RedisStore{client: client, prefix: prefix}, nil
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, s.prefix+key, value, ttl).Err()
+}
+
+func (s *RedisStore) Purge(ctx context.Context) error {
+	iter := s.client.Scan(ctx, 0, s.prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		if err := s.client.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// Stats counts the keys under prefix, bytes and evictions are left to redis itself.
+func (s *RedisStore) Stats(ctx context.Context) (StoreStats, error) {
+	stats := StoreStats{Backend: "redis"}
+	iter := s.client.Scan(ctx, 0, s.prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		stats.Entries++
+	}
+	return stats, iter.Err()
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testCacheStore(t *testing.T, s CacheStore) {
+	ctx := context.Background()
+	assert.NoError(t, s.Purge(ctx))
+	_, found, err := s.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.NoError(t, s.Set(ctx, "a", []byte("1"), time.Minute))
+	assert.NoError(t, s.Set(ctx, "b", []byte("2"), 10*time.Millisecond))
+	value, found, err := s.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("1"), value)
+
+	time.Sleep(20 * time.Millisecond)
+	_, found, err = s.Get(ctx, "b")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.NoError(t, s.Purge(ctx))
+	_, found, err = s.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func Test_MemoryStore(t *testing.T) {
+	testCacheStore(t, NewMemoryStore(CacheLimits{}))
+}
+
+func Test_BoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	s, err := NewBoltStore(path)
+	assert.NoError(t, err)
+	testCacheStore(t, s)
+
+	// values survive reopening
+	assert.NoError(t, s.Set(context.Background(), "a", []byte("1"), time.Minute))
+	assert.NoError(t, s.Close())
+	s, err = NewBoltStore(path)
+	assert.NoError(t, err)
+	defer s.Close()
+	value, found, err := s.Get(context.Background(), "a")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("1"), value)
+}
+
+func Test_RedisStore(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR is not set")
+	}
+	s, err := NewRedisStore(context.Background(), &redis.Options{Addr: addr}, "finviz-proxy-test:")
+	assert.NoError(t, err)
+	defer s.Close()
+	testCacheStore(t, s)
+}
@@ -6,21 +6,82 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
 	"log/slog"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+const (
+	defaultView  = "111" // overview
+	defaultOrder = "ticker"
+
+	MaxTickers        = 200
+	TickersPerRequest = 20 // rows of a screener page, larger ticker lists are fetched in chunks
+)
+
+var tickerPattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9.\-]{0,9}$`)
+
 type TableParams struct {
 	Order   string   `json:"order"`
 	Desc    bool     `json:"desc"`
 	Signal  string   `json:"signal"`
 	Filters []string `json:"filters"`
+	Tickers []string `json:"tickers,omitempty"` // rows are returned in this order
 }
 
+// Normalize turns params into the canonical form, so equivalent screens build the same uri:
+// filters are sorted and deduplicated, and order defaults to ticker.
+func (p *TableParams) Normalize() {
+	if p.Order == "" {
+		p.Order = defaultOrder
+	}
+	if len(p.Filters) > 0 {
+		sort.Strings(p.Filters)
+		filters := p.Filters[:1]
+		for _, filter := range p.Filters[1:] {
+			if filter != filters[len(filters)-1] {
+				filters = append(filters, filter)
+			}
+		}
+		p.Filters = filters
+	}
+	if len(p.Tickers) > 0 {
+		seen := make(map[string]bool, len(p.Tickers))
+		tickers := p.Tickers[:0]
+		for _, ticker := range p.Tickers {
+			if !seen[ticker] {
+				seen[ticker] = true
+				tickers = append(tickers, ticker)
+			}
+		}
+		p.Tickers = tickers
+	}
+}
+
+// Chunks splits params by TickersPerRequest tickers, params without more tickers is the only chunk.
+func (p *TableParams) Chunks() []*TableParams {
+	if len(p.Tickers) <= TickersPerRequest {
+		return []*TableParams{p}
+	}
+	chunks := make([]*TableParams, 0, (len(p.Tickers)+TickersPerRequest-1)/TickersPerRequest)
+	for start := 0; start < len(p.Tickers); start += TickersPerRequest {
+		chunk := *p
+		chunk.Tickers = p.Tickers[start:min(start+TickersPerRequest, len(p.Tickers))]
+		chunks = append(chunks, &chunk)
+	}
+	return chunks
+}
+
+// BuildUri builds the query of the screener page, the view is always set to overview.
 func (p *TableParams) BuildUri() string {
-	ret := ""
+	ret := "v=" + defaultView
 	if p.Order != "" {
+		ret += "&"
 		if p.Desc {
 			ret += "o=-" + p.Order
 		} else {
@@ -28,16 +89,10 @@ func (p *TableParams) BuildUri() string {
 		}
 	}
 	if p.Signal != "" {
-		if ret != "" {
-			ret += "&"
-		}
-		ret += "s=" + p.Signal
+		ret += "&s=" + p.Signal
 	}
 	if len(p.Filters) > 0 {
-		if ret != "" {
-			ret += "&"
-		}
-		ret += "f="
+		ret += "&f="
 		for i, filter := range p.Filters {
 			if i != 0 {
 				ret += ","
@@ -45,98 +100,83 @@ func (p *TableParams) BuildUri() string {
 			ret += filter
 		}
 	}
+	if len(p.Tickers) > 0 {
+		ret += "&t=" + strings.Join(p.Tickers, ",")
+	}
 	return ret
 }
 
-func checkSorter(allowParams *Params, order string) bool {
-	for _, sorter := range allowParams.Sorters {
-		if sorter.Value == order {
-			return true
-		}
+// CacheKey is the canonical uri scoped by the session, so delayed and real-time tables never mix.
+func (p *TableParams) CacheKey(isElite bool) string {
+	if isElite {
+		return "elite:" + p.BuildUri()
 	}
-	return false
+	return "free:" + p.BuildUri()
 }
 
-func checkSignal(allowParams *Params, signal string) bool {
-	for _, s := range allowParams.Signals {
-		if s.Value == signal {
-			return true
-		}
+func parseBool(value string) (bool, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "yes", "on":
+		return true, true
+	case "", "0", "false", "no", "off":
+		return false, true
 	}
-	return false
+	return false, false
 }
 
-func checkFilter(allowParams *Params, filter string) bool {
-	for _, f := range allowParams.Filters {
-		for _, o := range f.Options {
-			if o.Value == filter {
-				return true
-			}
-		}
+// parseTickers upper cases and checks tickers, and reports invalid ones by their index of param.
+func parseTickers(param string, tickers []string) ([]string, ParamsErrors) {
+	if len(tickers) > MaxTickers {
+		return nil, ParamsErrors{NewParamsError(CodeTooManyTickers, param, strconv.Itoa(len(tickers)))}
 	}
-	return false
-}
-
-func checkFilterV2(allowParams *Params, key string, value string) bool {
-	for _, f := range allowParams.Filters {
-		if f.Id == key {
-			for _, o := range f.Options {
-				if o.Value == value {
-					return true
-				}
-			}
+	var paramsErrors ParamsErrors
+	ret := make([]string, 0, len(tickers))
+	for i, ticker := range tickers {
+		ticker = strings.ToUpper(strings.TrimSpace(ticker))
+		if !tickerPattern.MatchString(ticker) {
+			paramsErrors = append(paramsErrors, NewParamsError(CodeInvalidTicker, fmt.Sprintf("%s[%d]", param, i), tickers[i]))
+			continue
 		}
+		ret = append(ret, ticker)
 	}
-	return false
-}
-
-type ParamsError struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
-}
-
-func NewParamsError(key string, value string) *ParamsError {
-	return &ParamsError{
-		Key:   key,
-		Value: value,
-	}
-}
-
-func IsParamsError(err error) bool {
-	_, ok := err.(*ParamsError)
-	return ok
-}
-
-func (p *ParamsError) Error() string {
-	return fmt.Sprintf("%s: %s", p.Key, p.Value)
+	return ret, paramsErrors
 }
 
+// ParseTableParams checks the query against allowParams, and reports all invalid params at once as ParamsErrors.
 func ParseTableParams(allowParams *Params, query map[string][]string) (*TableParams, error) {
+	index := allowParams.Index()
+	var paramsErrors ParamsErrors
 	for k := range query {
-		if k != "order" && k != "desc" && k != "signal" && k != "auth" &&
+		if k != "order" && k != "desc" && k != "signal" && k != "auth" && k != "tickers" &&
 			k != "filters" && !strings.HasPrefix(k, "filters[") {
-			return nil, NewParamsError("invalid_key", k)
+			paramsErrors = append(paramsErrors, NewParamsError(CodeInvalidKey, k, strings.Join(query[k], ",")))
 		}
 	}
 
 	params := &TableParams{}
 	if order, ok := query["order"]; ok {
 		if len(order) > 0 {
-			if !checkSorter(allowParams, order[0]) {
-				return nil, NewParamsError("invalid_order", order[0])
+			if !index.HasSorter(order[0]) {
+				paramsErrors = append(paramsErrors, NewParamsError(CodeInvalidOrder, "order", order[0]).
+					WithSuggestions(index.SuggestSorters(order[0])))
 			}
 			params.Order = order[0]
 		}
 	}
 	if desc, ok := query["desc"]; ok {
-		if len(desc) > 0 && (desc[0] == "1" || strings.ToLower(desc[0]) == "true") {
-			params.Desc = true
+		if len(desc) > 0 {
+			value, ok := parseBool(desc[0])
+			if !ok {
+				paramsErrors = append(paramsErrors, NewParamsError(CodeInvalidDesc, "desc", desc[0]))
+			}
+			params.Desc = value
 		}
 	}
 	if signal, ok := query["signal"]; ok {
 		if len(signal) > 0 {
-			if !checkSignal(allowParams, signal[0]) {
-				return nil, NewParamsError("invalid_signal", signal[0])
+			if !index.HasSignal(signal[0]) {
+				paramsErrors = append(paramsErrors, NewParamsError(CodeInvalidSignal, "signal", signal[0]).
+					WithSuggestions(index.SuggestSignals(signal[0])))
 			}
 			params.Signal = signal[0]
 		}
@@ -144,49 +184,187 @@ func ParseTableParams(allowParams *Params, query map[string][]string) (*TablePar
 	for k, v := range query {
 		if k == "filters" || strings.HasPrefix(k, "filters[") {
 			for _, filter := range v {
-				if !checkFilter(allowParams, filter) {
-					return nil, NewParamsError("invalid_filter", filter)
+				if !index.HasOption("", filter) {
+					paramsErrors = append(paramsErrors, NewParamsError(CodeInvalidFilter, k, filter).
+						WithSuggestions(index.SuggestOptions("", filter)))
 				}
 			}
 			params.Filters = append(params.Filters, v...)
 		}
 	}
+	// tickers are comma separated, or repeated
+	if values, ok := query["tickers"]; ok {
+		var tickers []string
+		for _, value := range values {
+			tickers = append(tickers, strings.Split(value, ",")...)
+		}
+		var errs ParamsErrors
+		params.Tickers, errs = parseTickers("tickers", tickers)
+		paramsErrors = append(paramsErrors, errs...)
+	}
+	if len(paramsErrors) > 0 {
+		return nil, paramsErrors.sorted()
+	}
+	params.Normalize()
 	return params, nil
 }
 
-func ParseTableParamsV2(allowParams *Params, body io.Reader) (*TableParams, error) {
+// FilterRange is a custom range of a numeric filter, either bound can be omitted.
+type FilterRange struct {
+	From *float64 `json:"from"`
+	To   *float64 `json:"to"`
+}
+
+func formatBound(bound *float64) string {
+	if bound == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*bound, 'f', -1, 64)
+}
+
+// parseFilterV2 resolves the value of the filter key into a finviz filter token. The value is an option,
+// an array of options joined as exch_nasd|nyse, or a FilterRange as fa_pe_5to20, the last two are Elite only.
+func parseFilterV2(index *ParamsIndex, isElite bool, key string, raw json.RawMessage) (string, ParamsErrors) {
+	param := "filters." + key
+	id, ok := index.ResolveFilter(key)
+	if !ok {
+		return "", ParamsErrors{NewParamsError(CodeInvalidKey, param, key).WithSuggestions(index.SuggestFilters(key))}
+	}
+	var value string
+	var values []string
+	var bounds FilterRange
+	switch {
+	case json.Unmarshal(raw, &value) == nil:
+		token, ok := index.ResolveOption(id, value)
+		if !ok {
+			return "", ParamsErrors{NewParamsError(CodeInvalidFilter, param, value).WithSuggestions(index.SuggestOptions(id, value))}
+		}
+		return token, nil
+	case json.Unmarshal(raw, &values) == nil:
+		if len(values) == 0 {
+			return "", ParamsErrors{NewParamsError(CodeInvalidFilter, param, string(raw))}
+		}
+		if len(values) > 1 && !isElite {
+			return "", ParamsErrors{NewParamsError(CodeEliteRequired, param, string(raw))}
+		}
+		prefix := index.Prefix(id)
+		if len(values) > 1 && prefix == "" {
+			return "", ParamsErrors{NewParamsError(CodeInvalidFilter, param, string(raw))}
+		}
+		var paramsErrors ParamsErrors
+		suffixes := make([]string, 0, len(values))
+		for i, value := range values {
+			token, ok := index.ResolveOption(id, value)
+			if !ok {
+				paramsErrors = append(paramsErrors, NewParamsError(CodeInvalidFilter, fmt.Sprintf("%s[%d]", param, i), value).
+					WithSuggestions(index.SuggestOptions(id, value)))
+				continue
+			}
+			suffixes = append(suffixes, strings.TrimPrefix(token, prefix))
+		}
+		if len(paramsErrors) > 0 {
+			return "", paramsErrors
+		}
+		sort.Strings(suffixes)
+		return prefix + strings.Join(slices.Compact(suffixes), "|"), nil
+	case json.Unmarshal(raw, &bounds) == nil:
+		if !isElite {
+			return "", ParamsErrors{NewParamsError(CodeEliteRequired, param, string(raw))}
+		}
+		domain, ok := index.Range(id)
+		if !ok ||
+			bounds.From == nil && bounds.To == nil ||
+			bounds.From != nil && !domain.Contains(*bounds.From) ||
+			bounds.To != nil && !domain.Contains(*bounds.To) ||
+			bounds.From != nil && bounds.To != nil && *bounds.From > *bounds.To {
+			return "", ParamsErrors{NewParamsError(CodeInvalidRange, param, string(raw))}
+		}
+		return index.Prefix(id) + formatBound(bounds.From) + "to" + formatBound(bounds.To), nil
+	}
+	return "", ParamsErrors{NewParamsError(CodeInvalidFilter, param, string(raw))}
+}
+
+// tableParamsV2Keys are the keys of a ParseTableParamsV2 body, others are invalid_key errors as in ParseTableParams.
+var tableParamsV2Keys = map[string]bool{"order": true, "desc": true, "signal": true, "filters": true, "tickers": true}
+
+// ParseTableParamsV2 is ParseTableParams of a json body, a body which is not valid json is an invalid_body error.
+// Besides values, order, signal, filter ids and filter values can be human names as in Params, case-insensitive.
+// Multiple options and custom ranges of a filter require an Elite session, see parseFilterV2.
+func ParseTableParamsV2(ctx context.Context, allowParams *Params, body io.Reader, isElite bool) (*TableParams, error) {
 	req := &struct {
-		Order   string            `json:"order"`
-		Desc    bool              `json:"desc"`
-		Signal  string            `json:"signal"`
-		Filters map[string]string `json:"filters"`
+		Order   string                     `json:"order"`
+		Desc    bool                       `json:"desc"`
+		Signal  string                     `json:"signal"`
+		Filters map[string]json.RawMessage `json:"filters"`
+		Tickers []string                   `json:"tickers"`
 	}{}
-	decoder := json.NewDecoder(body)
-	err := decoder.Decode(req)
+	var raw json.RawMessage
+	var fields map[string]json.RawMessage
+	err := json.NewDecoder(body).Decode(&raw)
+	if err == nil {
+		err = json.Unmarshal(raw, &fields)
+	}
+	if err == nil {
+		err = json.Unmarshal(raw, req)
+	}
 	if err != nil {
-		slog.Error("failed to parse table params v2")
+		slog.WarnContext(ctx, "invalid table params v2 body", "err", err)
+		return nil, ParamsErrors{NewParamsError(CodeInvalidBody, "body", err.Error())}
 	}
 	// build TableParams
+	index := allowParams.Index()
+	var paramsErrors ParamsErrors
+	for k, v := range fields {
+		if !tableParamsV2Keys[k] {
+			paramsErrors = append(paramsErrors, NewParamsError(CodeInvalidKey, k, string(v)))
+		}
+	}
 	params := &TableParams{}
 	if len(req.Order) > 0 {
-		if !checkSorter(allowParams, req.Order) {
-			return nil, NewParamsError("invalid_order", req.Order)
+		order, ok := index.ResolveSorter(req.Order)
+		if !ok {
+			paramsErrors = append(paramsErrors, NewParamsError(CodeInvalidOrder, "order", req.Order).
+				WithSuggestions(index.SuggestSorters(req.Order)))
 		}
-		params.Order = req.Order
+		params.Order = order
 	}
 	params.Desc = req.Desc
 	if len(req.Signal) > 0 {
-		if !checkSignal(allowParams, req.Signal) {
-			return nil, NewParamsError("invalid_signal", req.Signal)
+		signal, ok := index.ResolveSignal(req.Signal)
+		if !ok {
+			paramsErrors = append(paramsErrors, NewParamsError(CodeInvalidSignal, "signal", req.Signal).
+				WithSuggestions(index.SuggestSignals(req.Signal)))
+		}
+		params.Signal = signal
+	}
+	// a filter given by both its id and name would be two conflicting tokens
+	keysOf := make(map[string]int, len(req.Filters))
+	for k := range req.Filters {
+		if id, ok := index.ResolveFilter(k); ok {
+			keysOf[id]++
 		}
-		params.Signal = req.Signal
 	}
 	for k, v := range req.Filters {
-		if !checkFilterV2(allowParams, k, v) {
-			return nil, NewParamsError("invalid_filter", v)
+		if id, ok := index.ResolveFilter(k); ok && keysOf[id] > 1 {
+			paramsErrors = append(paramsErrors, NewParamsError(CodeDuplicateFilter, "filters."+k, id))
+			continue
+		}
+		token, errs := parseFilterV2(index, isElite, k, v)
+		if len(errs) > 0 {
+			paramsErrors = append(paramsErrors, errs...)
+			continue
 		}
-		params.Filters = append(params.Filters, v)
+		params.Filters = append(params.Filters, token)
+	}
+	if len(req.Tickers) > 0 {
+		var errs ParamsErrors
+		params.Tickers, errs = parseTickers("tickers", req.Tickers)
+		paramsErrors = append(paramsErrors, errs...)
 	}
+	if len(paramsErrors) > 0 {
+		return nil, paramsErrors.sorted()
+	}
+	params.Normalize()
 	return params, nil
 }
 
@@ -195,15 +373,60 @@ type Table struct {
 	Rows    [][]string `json:"rows"`
 }
 
-func parseTable(page []byte) (*Table, error) {
+// MergeTables merges the tables of chunks of tickers, ordering rows as tickers and renumbering them.
+func MergeTables(tickers []string, tables []*Table) *Table {
+	merged := &Table{}
+	for _, table := range tables {
+		if len(table.Headers) > 0 {
+			merged.Headers = table.Headers
+			break
+		}
+	}
+	for _, table := range tables {
+		merged.Rows = append(merged.Rows, table.Rows...)
+	}
+	column := slices.Index(merged.Headers, "Ticker")
+	if column < 0 {
+		return merged
+	}
+	positions := make(map[string]int, len(tickers))
+	for i, ticker := range tickers {
+		positions[ticker] = i
+	}
+	position := func(row []string) int {
+		if column < len(row) {
+			if i, ok := positions[row[column]]; ok {
+				return i
+			}
+		}
+		return len(tickers) // unknown rows go last
+	}
+	sort.SliceStable(merged.Rows, func(i, j int) bool {
+		return position(merged.Rows[i]) < position(merged.Rows[j])
+	})
+	if len(merged.Headers) > 0 && merged.Headers[0] == "No." {
+		for i, row := range merged.Rows {
+			if len(row) > 0 {
+				row = slices.Clone(row) // rows are shared with the cached tables
+				row[0] = strconv.Itoa(i + 1)
+				merged.Rows[i] = row
+			}
+		}
+	}
+	return merged
+}
+
+func parseTable(ctx context.Context, page []byte) (table *Table, err error) {
+	_, span := tracer.Start(ctx, "parseTable")
+	defer func() { endSpan(span, err) }()
 	// parse table from page
 	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
 	if err != nil {
-		slog.Error("failed to parse table from page", "err", err)
+		slog.ErrorContext(ctx, "failed to parse table from page", "err", err)
 		return nil, err
 	}
 	// build table
-	table := &Table{}
+	table = &Table{}
 	thead := doc.Find("#screener-table").Find("thead")
 	thead.Find("th").Each(
 		func(i int, th *goquery.Selection) {
@@ -225,6 +448,7 @@ func parseTable(page []byte) (*Table, error) {
 			table.Rows = append(table.Rows, buf)
 		},
 	)
+	span.SetAttributes(attribute.Int("table.rows", len(table.Rows)))
 	return table, nil
 }
 
@@ -232,14 +456,15 @@ func FetchPageAndParseTable(ctx context.Context, uri string, isElite bool) (*Tab
 	// fetch page
 	page, err := fetchFinvizPage(ctx, uri, isElite)
 	if err != nil {
-		slog.Error("failed to fetch page", "err", err)
+		slog.ErrorContext(ctx, "failed to fetch page", "err", err)
 		return nil, err
 	}
 	// parse table
-	table, err := parseTable(page)
+	table, err := parseTable(ctx, page)
 	if err != nil {
-		slog.Error("failed to parse table", "err", err)
-		return nil, err
+		slog.ErrorContext(ctx, "failed to parse table", "err", err)
+		parseFailures.WithLabelValues("parseTable").Inc()
+		return nil, &ParseError{Parser: "parseTable", Err: err}
 	}
 	return table, nil
 }
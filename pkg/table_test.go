@@ -1,8 +1,13 @@
 package pkg
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"math"
 	"os"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -12,9 +17,288 @@ func Test_parseTable(t *testing.T) {
 	// read file screener.ashx.html
 	page, err := os.ReadFile("screener.ashx")
 	assert.NoError(t, err)
-	table, err := parseTable(page)
+	table, err := parseTable(context.Background(), page)
 	assert.NoError(t, err)
 	j, err := json.MarshalIndent(table, "", "  ")
 	assert.NoError(t, err)
 	println(string(j))
 }
+
+var testParams = &Params{
+	Filters: []Filter{
+		{Id: "fs_exch", Name: "Exchange", Options: []FilterOption{
+			{Name: "AMEX", Value: "exch_amex"},
+			{Name: "NASDAQ", Value: "exch_nasd"},
+		}},
+		{Id: "fs_idx", Name: "Index", Options: []FilterOption{
+			{Name: "S&P 500", Value: "idx_sp500"},
+		}},
+	},
+	Sorters: []Sorter{{Name: "Ticker", Value: "ticker"}, {Name: "Price", Value: "price"}},
+	Signals: []Signal{{Name: "Top Gainers", Value: "ta_topgainers"}},
+}
+
+func Test_TableParams_canonical(t *testing.T) {
+	v1, err := ParseTableParams(testParams, map[string][]string{
+		"desc":    {"TRUE"},
+		"filters": {"idx_sp500", "exch_nasd", "idx_sp500"},
+	})
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		v2, err := ParseTableParamsV2(context.Background(), testParams, strings.NewReader(
+			`{"order": "ticker", "desc": true, "filters": {"fs_exch": "exch_nasd", "fs_idx": "idx_sp500"}}`,
+		), false)
+		assert.NoError(t, err)
+		assert.Equal(t, "v=111&o=-ticker&f=exch_nasd,idx_sp500", v2.BuildUri())
+		assert.Equal(t, v1.CacheKey(false), v2.CacheKey(false))
+	}
+	assert.NotEqual(t, v1.CacheKey(false), v1.CacheKey(true))
+
+	_, err = ParseTableParams(testParams, map[string][]string{"desc": {"maybe"}})
+	assert.Error(t, err)
+}
+
+func Test_ParseTableParams_allErrors(t *testing.T) {
+	_, err := ParseTableParams(testParams, map[string][]string{
+		"order":   {"unknown"},
+		"desc":    {"maybe"},
+		"filters": {"exch_nasd", "exch_nyse"},
+		"page":    {"2"},
+	})
+	assert.True(t, IsParamsError(err))
+	assert.Equal(t, ParamsErrors{
+		{Code: CodeInvalidDesc, Param: "desc", Value: "maybe"},
+		{Code: CodeInvalidFilter, Param: "filters", Value: "exch_nyse", Suggestions: []string{"exch_nasd"}},
+		{Code: CodeInvalidOrder, Param: "order", Value: "unknown"},
+		{Code: CodeInvalidKey, Param: "page", Value: "2"},
+	}, err)
+
+	_, err = ParseTableParamsV2(context.Background(), testParams, strings.NewReader(
+		`{"signal": "unknown", "filters": {"fs_exch": "idx_sp500", "fs_idx": "idx_sp500"}}`,
+	), false)
+	assert.Equal(t, ParamsErrors{
+		{Code: CodeInvalidFilter, Param: "filters.fs_exch", Value: "idx_sp500"},
+		{Code: CodeInvalidSignal, Param: "signal", Value: "unknown"},
+	}, err)
+
+	// unknown keys are reported as in v1, instead of being ignored
+	_, err = ParseTableParamsV2(context.Background(), testParams, strings.NewReader(
+		`{"ticker": "AAPL", "filter": {"fs_exch": "exch_nasd"}, "order": "price"}`,
+	), false)
+	assert.Equal(t, ParamsErrors{
+		{Code: CodeInvalidKey, Param: "filter", Value: `{"fs_exch": "exch_nasd"}`},
+		{Code: CodeInvalidKey, Param: "ticker", Value: `"AAPL"`},
+	}, err)
+
+	_, err = ParseTableParamsV2(context.Background(), testParams, strings.NewReader(`{"order":`), false)
+	problem := ParamsProblem(err)
+	assert.Equal(t, 400, problem.Status)
+	assert.Equal(t, CodeInvalidParams, problem.Code)
+	assert.Len(t, problem.Errors, 1)
+	assert.Equal(t, CodeInvalidBody, problem.Errors[0].Code)
+}
+
+func Test_ParseTableParams_suggestions(t *testing.T) {
+	_, err := ParseTableParams(testParams, map[string][]string{
+		"order":   {"Prices"},
+		"filters": {"exch_nsad", "nasdaq", "fa_pe_u20"},
+	})
+	assert.Equal(t, ParamsErrors{
+		{Code: CodeInvalidFilter, Param: "filters", Value: "exch_nsad", Suggestions: []string{"exch_nasd"}},
+		{Code: CodeInvalidFilter, Param: "filters", Value: "fa_pe_u20"},
+		{Code: CodeInvalidFilter, Param: "filters", Value: "nasdaq", Suggestions: []string{"exch_nasd"}},
+		{Code: CodeInvalidOrder, Param: "order", Value: "Prices", Suggestions: []string{"price"}},
+	}, err)
+
+	_, err = ParseTableParamsV2(context.Background(), testParams, strings.NewReader(`{"filters": {"fs_exh": "exch_nasd", "fs_idx": "idx_sp50"}}`), false)
+	assert.Equal(t, ParamsErrors{
+		{Code: CodeInvalidKey, Param: "filters.fs_exh", Value: "fs_exh", Suggestions: []string{"fs_exch"}},
+		{Code: CodeInvalidFilter, Param: "filters.fs_idx", Value: "idx_sp50", Suggestions: []string{"idx_sp500"}},
+	}, err)
+}
+
+func Test_levenshtein(t *testing.T) {
+	assert.Equal(t, 0, levenshtein("", ""))
+	assert.Equal(t, 3, levenshtein("", "abc"))
+	assert.Equal(t, 3, levenshtein("kitten", "sitting"))
+	assert.Equal(t, 1, levenshtein("fa_pe_u20", "fa_pe_u2o"))
+	assert.Equal(t, 1, levenshtein("héllo", "hello"))
+}
+
+func Test_ParseTableParamsV2_names(t *testing.T) {
+	byNames, err := ParseTableParamsV2(context.Background(), testParams, strings.NewReader(
+		`{"order": "PRICE", "desc": true, "signal": "top gainers", "filters": {"Exchange": "nasdaq", "index": "S&P 500"}}`,
+	), false)
+	assert.NoError(t, err)
+	byValues, err := ParseTableParamsV2(context.Background(), testParams, strings.NewReader(
+		`{"order": "price", "desc": true, "signal": "ta_topgainers", "filters": {"fs_exch": "exch_nasd", "fs_idx": "idx_sp500"}}`,
+	), false)
+	assert.NoError(t, err)
+	assert.Equal(t, byValues, byNames)
+	assert.Equal(t, "v=111&o=-price&s=ta_topgainers&f=exch_nasd,idx_sp500", byNames.BuildUri())
+
+	// labels only resolve within their own filter
+	_, err = ParseTableParamsV2(context.Background(), testParams, strings.NewReader(`{"filters": {"Index": "NASDAQ"}}`), false)
+	assert.Equal(t, ParamsErrors{{Code: CodeInvalidFilter, Param: "filters.Index", Value: "NASDAQ"}}, err)
+
+	// a filter given by both its name and id is reported instead of fetching conflicting options
+	_, err = ParseTableParamsV2(context.Background(), testParams, strings.NewReader(
+		`{"filters": {"Exchange": "nasdaq", "fs_exch": "exch_nyse", "fs_idx": "idx_sp500"}}`,
+	), false)
+	assert.Equal(t, ParamsErrors{
+		{Code: CodeDuplicateFilter, Param: "filters.Exchange", Value: "fs_exch"},
+		{Code: CodeDuplicateFilter, Param: "filters.fs_exch", Value: "fs_exch"},
+	}, err)
+}
+
+func Test_ParseTableParamsV2_multiAndRange(t *testing.T) {
+	params := &Params{
+		Filters: append(slices.Clone(testParams.Filters),
+			Filter{Id: "fs_fa_pe", Name: "P/E", Options: []FilterOption{
+				{Name: "Low (<15)", Value: "fa_pe_low"},
+				{Name: "Under 20", Value: "fa_pe_u20"},
+				{Name: "Over 50", Value: "fa_pe_o50"},
+			}},
+			Filter{Id: "fs_ta_change", Name: "Change", Options: []FilterOption{
+				{Name: "Up 5%", Value: "ta_change_u5"},
+				{Name: "Down 5%", Value: "ta_change_d5"},
+			}},
+			Filter{Id: "fs_fa_epsyoy", Name: "EPS growth this year", Options: []FilterOption{
+				{Name: "Negative (<0%)", Value: "fa_epsyoy_neg"},
+				{Name: "Positive (>0%)", Value: "fa_epsyoy_pos"},
+				{Name: "Over 20%", Value: "fa_epsyoy_o20"},
+			}},
+			Filter{Id: "fs_sh_price", Name: "Price", Options: []FilterOption{
+				{Name: "Under $5", Value: "sh_price_u5"},
+				{Name: "$5 to $10", Value: "sh_price_5to10"},
+				{Name: "Over $50", Value: "sh_price_o50"},
+			}},
+			Filter{Id: "fs_fa_payoutratio", Name: "Payout Ratio", Options: []FilterOption{
+				{Name: "Under 10%", Value: "fa_payoutratio_u10"},
+				{Name: "10% to 50%", Value: "fa_payoutratio_10to50"},
+			}},
+		),
+	}
+	parse := func(body string, isElite bool) (*TableParams, error) {
+		return ParseTableParamsV2(context.Background(), params, strings.NewReader(body), isElite)
+	}
+
+	tableParams, err := parse(`{"filters": {
+		"fs_exch": ["nasdaq", "exch_amex", "NASDAQ"],
+		"P/E": {"from": 5, "to": 20.5},
+		"fs_ta_change": {"from": -10}
+	}}`, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "v=111&o=ticker&f=exch_amex|nasd,fa_pe_5to20.5,ta_change_-10to", tableParams.BuildUri())
+
+	// a single option in an array is the same as the option
+	tableParams, err = parse(`{"filters": {"fs_exch": ["exch_nasd"]}}`, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"exch_nasd"}, tableParams.Filters)
+
+	_, err = parse(`{"filters": {"fs_exch": ["exch_nasd", "exch_amex"], "fs_fa_pe": {"to": 20}}}`, false)
+	assert.Equal(t, ParamsErrors{
+		{Code: CodeEliteRequired, Param: "filters.fs_exch", Value: `["exch_nasd", "exch_amex"]`},
+		{Code: CodeEliteRequired, Param: "filters.fs_fa_pe", Value: `{"to": 20}`},
+	}, err)
+
+	_, err = parse(`{"filters": {
+		"fs_exch": ["exch_nasd", "exch_nsye"],
+		"fs_idx": {"from": 1},
+		"fs_fa_pe": {"from": 20, "to": 5},
+		"fs_ta_change": 5
+	}}`, true)
+	assert.Equal(t, ParamsErrors{
+		{Code: CodeInvalidFilter, Param: "filters.fs_exch[1]", Value: "exch_nsye", Suggestions: []string{"exch_nasd"}},
+		{Code: CodeInvalidRange, Param: "filters.fs_fa_pe", Value: `{"from": 20, "to": 5}`},
+		{Code: CodeInvalidRange, Param: "filters.fs_idx", Value: `{"from": 1}`},
+		{Code: CodeInvalidFilter, Param: "filters.fs_ta_change", Value: "5"},
+	}, err)
+
+	// filters with negative options, as neg or d5, take negative ranges
+	tableParams, err = parse(`{"filters": {"fs_ta_change": {"from": -10, "to": -5}, "fs_fa_epsyoy": {"from": -50, "to": 1000}}}`, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"fa_epsyoy_-50to1000", "ta_change_-10to-5"}, tableParams.Filters)
+	// others can't be negative, even with word options as low, but options are no upper bound
+	tableParams, err = parse(`{"filters": {"fs_sh_price": {"from": 0, "to": 1000}, "fs_fa_payoutratio": {"from": 10, "to": 60}, "fs_fa_pe": {"from": 100}}}`, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"fa_payoutratio_10to60", "fa_pe_100to", "sh_price_0to1000"}, tableParams.Filters)
+	_, err = parse(`{"filters": {"fs_sh_price": {"from": -1}, "fs_fa_pe": {"to": -1}}}`, true)
+	assert.Equal(t, ParamsErrors{
+		{Code: CodeInvalidRange, Param: "filters.fs_fa_pe", Value: `{"to": -1}`},
+		{Code: CodeInvalidRange, Param: "filters.fs_sh_price", Value: `{"from": -1}`},
+	}, err)
+}
+
+func Test_rangeDomainOf(t *testing.T) {
+	for _, tc := range []struct {
+		values []string
+		domain RangeDomain
+		ok     bool
+	}{
+		{[]string{"x_low", "x_high"}, RangeDomain{}, false},
+		{[]string{"x_neg", "x_u10"}, RangeDomain{Min: math.Inf(-1), Max: math.Inf(1)}, true},
+		{[]string{"x_low", "x_u5", "x_5to10", "x_o50"}, RangeDomain{Min: 0, Max: math.Inf(1)}, true},
+		{[]string{"x_u5", "x_d5"}, RangeDomain{Min: math.Inf(-1), Max: math.Inf(1)}, true},
+		{[]string{"x_u0", "x_o0"}, RangeDomain{Min: math.Inf(-1), Max: math.Inf(1)}, true},
+		{[]string{"x_-10to-5", "x_0to0.5"}, RangeDomain{Min: math.Inf(-1), Max: math.Inf(1)}, true},
+		{[]string{"x_u10", "x_10to50"}, RangeDomain{Min: 0, Max: math.Inf(1)}, true},
+	} {
+		filter := Filter{Id: "fs_x"}
+		for _, value := range tc.values {
+			filter.Options = append(filter.Options, FilterOption{Value: value})
+		}
+		domain, ok := rangeDomainOf(filter, "x_")
+		assert.Equal(t, tc.ok, ok, tc.values)
+		if ok {
+			assert.Equal(t, tc.domain, domain, tc.values)
+		}
+	}
+}
+
+func Test_TableParams_tickers(t *testing.T) {
+	params, err := ParseTableParams(testParams, map[string][]string{"tickers": {"aapl,msft", " brk-b ", "AAPL"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AAPL", "MSFT", "BRK-B"}, params.Tickers)
+	assert.Equal(t, "v=111&o=ticker&t=AAPL,MSFT,BRK-B", params.BuildUri())
+
+	v2, err := ParseTableParamsV2(context.Background(), testParams, strings.NewReader(`{"tickers": ["aapl", "msft", "brk-b"]}`), false)
+	assert.NoError(t, err)
+	assert.Equal(t, params, v2)
+
+	_, err = ParseTableParamsV2(context.Background(), testParams, strings.NewReader(`{"tickers": ["AAPL", "", "A B"]}`), false)
+	assert.Equal(t, ParamsErrors{
+		{Code: CodeInvalidTicker, Param: "tickers[1]", Value: ""},
+		{Code: CodeInvalidTicker, Param: "tickers[2]", Value: "A B"},
+	}, err)
+	_, err = ParseTableParams(testParams, map[string][]string{"tickers": {strings.Repeat("A,", MaxTickers) + "A"}})
+	assert.Equal(t, ParamsErrors{{Code: CodeTooManyTickers, Param: "tickers", Value: "201"}}, err)
+
+	tickers := make([]string, 45)
+	for i := range tickers {
+		tickers[i] = fmt.Sprintf("T%d", i)
+	}
+	params = &TableParams{Order: "price", Tickers: tickers}
+	chunks := params.Chunks()
+	assert.Len(t, chunks, 3)
+	assert.Equal(t, tickers[:20], chunks[0].Tickers)
+	assert.Equal(t, tickers[40:], chunks[2].Tickers)
+	assert.Equal(t, "price", chunks[2].Order)
+	noTickers := &TableParams{}
+	assert.Equal(t, []*TableParams{noTickers}, noTickers.Chunks())
+	assert.Len(t, (&TableParams{Tickers: tickers[:20]}).Chunks(), 1)
+}
+
+func Test_MergeTables(t *testing.T) {
+	headers := []string{"No.", "Ticker", "Price"}
+	first := &Table{Headers: headers, Rows: [][]string{{"1", "AAPL", "200"}, {"2", "MSFT", "400"}}}
+	second := &Table{Headers: headers, Rows: [][]string{{"1", "GOOG", "150"}}}
+	merged := MergeTables([]string{"MSFT", "GOOG", "AAPL"}, []*Table{first, second})
+	assert.Equal(t, &Table{Headers: headers, Rows: [][]string{
+		{"1", "MSFT", "400"},
+		{"2", "GOOG", "150"},
+		{"3", "AAPL", "200"},
+	}}, merged)
+	// the merged tables are not modified
+	assert.Equal(t, []string{"1", "AAPL", "200"}, first.Rows[0])
+}
@@ -0,0 +1,63 @@
+package pkg
+
+import (
+	"context"
+	"sync"
+)
+
+// TaskGroup runs background tasks under a context canceled on shutdown, so they can't outlive what they use.
+type TaskGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+func NewTaskGroup() *TaskGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TaskGroup{ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn in a goroutine with the context of g, and returns false without running it if g is stopped.
+func (g *TaskGroup) Go(fn func(ctx context.Context)) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return false
+	}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		fn(g.ctx)
+	}()
+	return true
+}
+
+// Stop stops starting new tasks, running ones keep running.
+func (g *TaskGroup) Stop() {
+	g.mu.Lock()
+	g.stopped = true
+	g.mu.Unlock()
+}
+
+// Shutdown stops starting new tasks and waits for running ones until ctx is done,
+// then cancels them and waits for them to return. It returns false if they had to be canceled.
+func (g *TaskGroup) Shutdown(ctx context.Context) bool {
+	g.Stop()
+	defer g.cancel()
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		g.cancel()
+		<-done
+		return false
+	}
+}
@@ -0,0 +1,39 @@
+package pkg
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_TaskGroup(t *testing.T) {
+	// running tasks are waited for
+	g := NewTaskGroup()
+	finished := make(chan struct{})
+	assert.True(t, g.Go(func(ctx context.Context) {
+		time.Sleep(20 * time.Millisecond)
+		close(finished)
+	}))
+	assert.True(t, g.Shutdown(context.Background()))
+	<-finished
+	// and no new task is started after shutdown
+	assert.False(t, g.Go(func(ctx context.Context) {
+		t.Error("started after shutdown")
+	}))
+
+	// tasks still running at the deadline are canceled
+	g = NewTaskGroup()
+	var err error
+	g.Go(func(ctx context.Context) {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-time.After(time.Second):
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.False(t, g.Shutdown(ctx))
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,19 @@
+package pkg
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates spans with the global tracer provider, it is a no-op until one is set with otel.SetTracerProvider.
+var tracer = otel.Tracer("github.com/ppaanngggg/finviz-proxy/pkg")
+
+// endSpan records err on span if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,40 @@
+package pkg
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+	"time"
+)
+
+func Test_tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	ctx, root := tracer.Start(context.Background(), "request")
+	cache := NewTableCache(CachePolicy{FreshTTL: time.Minute}, NewMemoryStore(CacheLimits{}))
+	cache.Get(ctx, "key")
+	_, err := parseTable(ctx, []byte(`<table id="screener-table"><thead><tr><th class="header">Ticker</th></tr></thead>
+<tbody><tr><td>AAPL</td></tr></tbody></table>`))
+	assert.NoError(t, err)
+	_, span := tracer.Start(ctx, "failing")
+	endSpan(span, errors.New("failed"))
+	root.End()
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	for _, name := range []string{"TableCache.Get", "parseTable", "failing"} {
+		assert.Equal(t, root.SpanContext().SpanID(), spans[name].Parent.SpanID(), name)
+	}
+	assert.Equal(t, codes.Error, spans["failing"].Status.Code)
+	assert.Equal(t, codes.Unset, spans["parseTable"].Status.Code)
+}
@@ -0,0 +1,168 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"go.etcd.io/bbolt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var usageBucket = []byte("usage")
+
+// UsageRecord is the count of requests of a subscriber to an endpoint in a UTC day.
+type UsageRecord struct {
+	Day          string `json:"day"` // 2006-01-02
+	User         string `json:"user"`
+	Subscription string `json:"subscription"`
+	Endpoint     string `json:"endpoint"`
+	Requests     uint64 `json:"requests"`
+}
+
+type usageKey struct {
+	day, user, subscription, endpoint string
+}
+
+// encode keys by day first, so a range of days is a range of keys.
+func (k usageKey) encode() []byte {
+	return []byte(k.day + "\x00" + k.user + "\x00" + k.subscription + "\x00" + k.endpoint)
+}
+
+func decodeUsageKey(b []byte) (usageKey, bool) {
+	parts := bytes.Split(b, []byte{0})
+	if len(parts) != 4 {
+		return usageKey{}, false
+	}
+	return usageKey{string(parts[0]), string(parts[1]), string(parts[2]), string(parts[3])}, true
+}
+
+// UsageStore counts requests per subscriber and endpoint in an embedded bbolt file.
+// Counts are buffered in memory and flushed every interval, and on Close.
+type UsageStore struct {
+	db   *bbolt.DB
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex
+	pending map[usageKey]uint64
+}
+
+func NewUsageStore(path string, interval time.Duration) (*UsageStore, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		slog.Error("failed to open usage store", "path", path, "err", err)
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(usageBucket)
+		return err
+	})
+	if err != nil {
+		slog.Error("failed to create usage bucket", "err", err)
+		db.Close()
+		return nil, err
+	}
+	s := &UsageStore{db: db, done: make(chan struct{}), pending: make(map[usageKey]uint64)}
+	s.wg.Add(1)
+	go s.flusher(interval)
+	return s, nil
+}
+
+// Record counts a request of the subscriber to endpoint at t.
+func (s *UsageStore) Record(user string, subscription string, endpoint string, t time.Time) {
+	key := usageKey{day: t.UTC().Format(time.DateOnly), user: user, subscription: subscription, endpoint: endpoint}
+	s.mu.Lock()
+	s.pending[key]++
+	s.mu.Unlock()
+}
+
+// Flush adds the buffered counts to the file.
+func (s *UsageStore) Flush() error {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[usageKey]uint64)
+	s.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(usageBucket)
+		for key, count := range pending {
+			k := key.encode()
+			if v := bucket.Get(k); len(v) == 8 {
+				count += binary.BigEndian.Uint64(v)
+			}
+			v := make([]byte, 8)
+			binary.BigEndian.PutUint64(v, count)
+			if err := bucket.Put(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// keep the counts for the next flush
+		s.mu.Lock()
+		for key, count := range pending {
+			s.pending[key] += count
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// Usage returns the flushed counts from day from to day to (2006-01-02, inclusive), of user if not empty.
+func (s *UsageStore) Usage(user string, from string, to string) ([]UsageRecord, error) {
+	records := make([]UsageRecord, 0)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(usageBucket).Cursor()
+		for k, v := cursor.Seek([]byte(from)); k != nil; k, v = cursor.Next() {
+			key, ok := decodeUsageKey(k)
+			if !ok || len(v) != 8 {
+				continue
+			}
+			if key.day > to {
+				break
+			}
+			if user != "" && key.user != user {
+				continue
+			}
+			records = append(records, UsageRecord{
+				Day:          key.day,
+				User:         key.user,
+				Subscription: key.subscription,
+				Endpoint:     key.endpoint,
+				Requests:     binary.BigEndian.Uint64(v),
+			})
+		}
+		return nil
+	})
+	return records, err
+}
+
+func (s *UsageStore) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	err := s.Flush()
+	if closeErr := s.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (s *UsageStore) flusher(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		if err := s.Flush(); err != nil {
+			slog.Error("failed to flush usage", "err", err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package pkg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_UsageStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.db")
+	s, err := NewUsageStore(path, time.Hour)
+	assert.NoError(t, err)
+	day1 := time.Date(2024, 8, 23, 23, 0, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Hour)
+	s.Record("alice", "BASIC", "/table", day1)
+	s.Record("alice", "BASIC", "/table", day1)
+	s.Record("bob", "PRO", "/news", day1)
+	s.Record("alice", "BASIC", "/table", day2)
+
+	// counts are buffered until flushed
+	usage, err := s.Usage("", "2024-08-23", "2024-08-24")
+	assert.NoError(t, err)
+	assert.Empty(t, usage)
+
+	assert.NoError(t, s.Flush())
+	s.Record("alice", "BASIC", "/table", day1)
+	// counts are added up, and flushed on close
+	assert.NoError(t, s.Close())
+	s, err = NewUsageStore(path, time.Hour)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	usage, err = s.Usage("alice", "2024-08-23", "2024-08-24")
+	assert.NoError(t, err)
+	assert.Equal(t, []UsageRecord{
+		{Day: "2024-08-23", User: "alice", Subscription: "BASIC", Endpoint: "/table", Requests: 3},
+		{Day: "2024-08-24", User: "alice", Subscription: "BASIC", Endpoint: "/table", Requests: 1},
+	}, usage)
+	usage, err = s.Usage("", "2024-08-23", "2024-08-23")
+	assert.NoError(t, err)
+	assert.Len(t, usage, 2)
+}